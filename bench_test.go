@@ -40,11 +40,55 @@ func BenchmarkAddCaveat(b *testing.B) {
 	}
 }
 
+func BenchmarkMintWithCaveats(b *testing.B) {
+	rootKey := randomBytes(24)
+	id := base64.StdEncoding.EncodeToString(randomBytes(100))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := b.N - 1; i >= 0; i-- {
+		m := MustNew(rootKey, id, "a location")
+		for _, cav := range benchmarkTemplateCaveats {
+			if err := m.AddFirstPartyCaveat(cav); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func BenchmarkTemplateMint(b *testing.B) {
+	rootKey := randomBytes(24)
+	id := base64.StdEncoding.EncodeToString(randomBytes(100))
+	t, err := macaroon.NewTemplate("a location")
+	if err != nil {
+		b.Fatal(err)
+	}
+	for _, cav := range benchmarkTemplateCaveats {
+		if err := t.AddFirstPartyCaveat(cav); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := b.N - 1; i >= 0; i-- {
+		if _, err := t.Mint(rootKey, id); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+var benchmarkTemplateCaveats = []string{
+	"time-before 2030-01-01T00:00:00Z",
+	"declared username someone",
+	"allow read write",
+	"ip 10.0.0.0/8",
+}
+
 func benchmarkVerify(b *testing.B, mspecs []macaroonSpec) {
 	rootKey, primary, discharges := makeMacaroons(mspecs)
 	check := func(string) error {
 		return nil
 	}
+	b.ReportAllocs()
 	b.ResetTimer()
 	for i := b.N - 1; i >= 0; i-- {
 		err := primary.Verify(rootKey, check, discharges)
@@ -58,6 +102,42 @@ func BenchmarkVerifyLarge(b *testing.B) {
 	benchmarkVerify(b, recursiveThirdPartyCaveatMacaroons)
 }
 
+func BenchmarkVerifyLargeCached(b *testing.B) {
+	rootKey, primary, discharges := makeMacaroons(recursiveThirdPartyCaveatMacaroons)
+	check := func(string) error {
+		return nil
+	}
+	opts := &macaroon.VerifyOptions{
+		Cache: macaroon.NewVerifyCache(100),
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := b.N - 1; i >= 0; i-- {
+		err := primary.VerifyWithOptions(rootKey, check, discharges, opts)
+		if err != nil {
+			b.Fatalf("verification failed: %v", err)
+		}
+	}
+}
+
+func BenchmarkVerifyLargeConcurrent(b *testing.B) {
+	rootKey, primary, discharges := makeMacaroons(recursiveThirdPartyCaveatMacaroons)
+	check := func(string) error {
+		return nil
+	}
+	opts := &macaroon.VerifyOptions{
+		Workers: 4,
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := b.N - 1; i >= 0; i-- {
+		err := primary.VerifyWithOptions(rootKey, check, discharges, opts)
+		if err != nil {
+			b.Fatalf("verification failed: %v", err)
+		}
+	}
+}
+
 func BenchmarkVerifySmall(b *testing.B) {
 	benchmarkVerify(b, []macaroonSpec{{
 		rootKey: "root-key",
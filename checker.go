@@ -0,0 +1,352 @@
+package macaroon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// First party caveat conditions checked by a Checker have the form
+// "cond arg": a condition name, which may not contain a space,
+// optionally followed by a space and an argument.
+
+// Condition returns a first party caveat with
+// the given condition name and argument.
+func Condition(name, arg string) string {
+	if arg == "" {
+		return name
+	}
+	return name + " " + arg
+}
+
+// ParseCaveat parses a first party caveat into
+// its condition name and argument.
+func ParseCaveat(cav string) (name, arg string, err error) {
+	if cav == "" {
+		return "", "", fmt.Errorf("empty caveat")
+	}
+	i := strings.IndexByte(cav, ' ')
+	if i < 0 {
+		return cav, "", nil
+	}
+	if i == 0 {
+		return "", "", fmt.Errorf("caveat starts with space character")
+	}
+	return cav[0:i], cav[i+1:], nil
+}
+
+// CheckerFunc checks that the first party caveat with the given
+// condition name and argument is satisfied in the given context.
+type CheckerFunc func(ctx context.Context, name, arg string) error
+
+// ErrCaveatNotRecognized is the error returned (possibly
+// wrapped) by Checker when a caveat condition has
+// not been registered.
+var ErrCaveatNotRecognized = errors.New("caveat not recognized")
+
+// Checker checks first party caveats by dispatching on
+// their condition names to registered checker functions.
+// Once all its functions have been registered, it is
+// safe to use concurrently.
+//
+// Each condition is either static, so that checking it always gives
+// the same result for the same macaroons, or dynamic, so that the
+// result may change between verifications, for example because it
+// depends on the current time or on the request being authorized.
+// When the result of a verification is cached (see VerifyOptions.Cache),
+// Verify checks dynamic conditions again on each cache hit.
+type Checker struct {
+	// Clock, if non-nil, is used to find the current time
+	// when checking time caveats. Otherwise time.Now is used.
+	Clock func() time.Time
+
+	// Skew holds the allowance for clock skew when checking
+	// time caveats: a time-before caveat is still satisfied
+	// up to Skew after its time, and a time-after caveat is
+	// satisfied from Skew before its time.
+	Skew time.Duration
+
+	// SignatureWindow holds the length of time for which a
+	// request signed by SignRequest is accepted when checking
+	// holder-key caveats. If it is zero, DefaultSignatureWindow
+	// is used.
+	SignatureWindow time.Duration
+
+	// MaxSignedBodySize holds the maximum size of a request body
+	// that will be read when checking holder-key caveats, as the
+	// body must be held in memory to check its signature. Requests
+	// with larger bodies do not satisfy holder-key caveats. If it
+	// is zero, DefaultMaxSignedBodySize is used; if it is negative,
+	// there is no limit.
+	MaxSignedBodySize int64
+
+	// Usage holds the store used by Verify to record uses of
+	// macaroons with uses caveats. If it is nil, uses caveats
+	// are never satisfied. Uses are recorded on every successful
+	// verification, including those that hit a VerifyCache.
+	Usage UsageStore
+
+	// funcs holds the functions for conditions
+	// with no namespace prefix.
+	funcs map[string]checkerEntry
+
+	// ns holds the namespaces registered with RegisterNS,
+	// and nsFuncs holds their functions.
+	ns      *Namespace
+	nsFuncs map[nsCondition]checkerEntry
+}
+
+// checkerEntry holds a registered checker function.
+type checkerEntry struct {
+	f       CheckerFunc
+	dynamic bool
+}
+
+// nsCondition identifies a condition within a namespace.
+type nsCondition struct {
+	uri  string
+	name string
+}
+
+// NewChecker returns a Checker with the standard
+// checker functions registered. The static ones are:
+//
+//	declared - see DeclaredCaveat.
+//	expr - see CondExpr; it is dynamic if any of its conditions are.
+//	ns - see NamespaceCaveat.
+//
+// and the dynamic ones are:
+//
+//	time-before - see TimeBeforeCaveat.
+//	time-after - see TimeAfterCaveat.
+//	http-method - see MethodsCaveat.
+//	http-path - see PathPrefixCaveat.
+//	http-host - see HostsCaveat.
+//	client-ip - see ClientIPCaveat.
+//	holder-key - see HolderKeyCaveat.
+//	uses - see UsesCaveat.
+func NewChecker() *Checker {
+	c := &Checker{
+		funcs:   make(map[string]checkerEntry),
+		ns:      NewNamespace(),
+		nsFuncs: make(map[nsCondition]checkerEntry),
+	}
+	c.Register(CondDeclared, checkDeclared)
+	c.Register(CondExpr, c.checkExpr)
+	c.Register(CondNamespace, checkNamespace)
+	c.RegisterDynamic(CondTimeBefore, c.checkTimeBefore)
+	c.RegisterDynamic(CondTimeAfter, c.checkTimeAfter)
+	c.RegisterDynamic(CondHTTPMethod, checkMethod)
+	c.RegisterDynamic(CondHTTPPath, checkPath)
+	c.RegisterDynamic(CondHTTPHost, checkHost)
+	c.RegisterDynamic(CondClientIP, checkClientIP)
+	c.RegisterDynamic(CondHolderKey, c.checkHolderKey)
+	c.RegisterDynamic(CondUses, c.checkUses)
+	return c
+}
+
+// Register registers the given function to check caveats with
+// the given static condition name, which has no namespace prefix.
+// It panics if the name is already registered or contains a space
+// or a colon.
+func (c *Checker) Register(name string, f CheckerFunc) {
+	c.register(name, f, false)
+}
+
+// RegisterDynamic is like Register except
+// that the condition is dynamic.
+func (c *Checker) RegisterDynamic(name string, f CheckerFunc) {
+	c.register(name, f, true)
+}
+
+func (c *Checker) register(name string, f CheckerFunc, dynamic bool) {
+	checkConditionName(name)
+	if _, ok := c.funcs[name]; ok {
+		panic(fmt.Sprintf("caveat condition %q is already registered", name))
+	}
+	c.funcs[name] = checkerEntry{f, dynamic}
+}
+
+// RegisterNS registers the given function to check caveats with the
+// given condition name in the namespace with the given URI. In caveats,
+// the condition is written with a prefix, as "prefix:name". The prefix
+// used by the checker itself is given, but the macaroons being checked
+// may use a different prefix for the same URI by declaring it with a
+// namespace caveat. RegisterNS panics if the name is already registered
+// in the namespace or if the prefix conflicts with an earlier one.
+// The condition is static.
+func (c *Checker) RegisterNS(uri, prefix, name string, f CheckerFunc) {
+	c.registerNS(uri, prefix, name, f, false)
+}
+
+// RegisterNSDynamic is like RegisterNS except
+// that the condition is dynamic.
+func (c *Checker) RegisterNSDynamic(uri, prefix, name string, f CheckerFunc) {
+	c.registerNS(uri, prefix, name, f, true)
+}
+
+func (c *Checker) registerNS(uri, prefix, name string, f CheckerFunc, dynamic bool) {
+	checkConditionName(name)
+	if err := c.ns.Register(uri, prefix); err != nil {
+		panic(err)
+	}
+	key := nsCondition{uri, name}
+	if _, ok := c.nsFuncs[key]; ok {
+		panic(fmt.Sprintf("caveat condition %q is already registered in namespace %q", name, uri))
+	}
+	c.nsFuncs[key] = checkerEntry{f, dynamic}
+}
+
+func checkConditionName(name string) {
+	if name == "" || strings.IndexAny(name, " :") >= 0 {
+		panic(fmt.Sprintf("invalid caveat condition name %q", name))
+	}
+}
+
+// Namespace returns a copy of the namespace holding
+// the URIs and prefixes registered with RegisterNS.
+func (c *Checker) Namespace() *Namespace {
+	ns, _ := ParseNamespace(c.ns.String())
+	return ns
+}
+
+// lookup returns the entry for the condition with the given
+// name, which may have a namespace prefix. Prefixes are resolved
+// with the namespace in the context, if any, and then with the
+// namespace of the checker.
+func (c *Checker) lookup(ctx context.Context, name string) (checkerEntry, error) {
+	i := strings.IndexByte(name, ':')
+	if i < 0 {
+		if e := c.funcs[name]; e.f != nil {
+			return e, nil
+		}
+		return checkerEntry{}, ErrCaveatNotRecognized
+	}
+	prefix := name[0:i]
+	uri, ok := NamespaceFromContext(ctx).URI(prefix)
+	if !ok {
+		uri, ok = c.ns.URI(prefix)
+	}
+	if !ok {
+		return checkerEntry{}, fmt.Errorf("unknown namespace prefix %q: %w", prefix, ErrCaveatNotRecognized)
+	}
+	if e := c.nsFuncs[nsCondition{uri, name[i+1:]}]; e.f != nil {
+		return e, nil
+	}
+	if _, ok := c.ns.Prefix(uri); !ok {
+		return checkerEntry{}, fmt.Errorf("unknown namespace %q: %w", uri, ErrCaveatNotRecognized)
+	}
+	return checkerEntry{}, ErrCaveatNotRecognized
+}
+
+// CheckFirstPartyCaveat checks the given caveat in the given context.
+func (c *Checker) CheckFirstPartyCaveat(ctx context.Context, cav string) error {
+	name, arg, err := ParseCaveat(cav)
+	if err != nil {
+		return fmt.Errorf("cannot parse caveat %q: %v", cav, err)
+	}
+	e, err := c.lookup(ctx, name)
+	if err != nil {
+		return fmt.Errorf("caveat %q not satisfied: %w", cav, err)
+	}
+	if err := e.f(ctx, name, arg); err != nil {
+		return fmt.Errorf("caveat %q not satisfied: %w", cav, err)
+	}
+	return nil
+}
+
+// IsDynamic reports whether the given caveat, as checked in the
+// given context, is dynamic. An expr caveat is dynamic if any of
+// its conditions are. Caveats that cannot be parsed or are not
+// recognized are treated as dynamic, so that checking them again
+// fails.
+func (c *Checker) IsDynamic(ctx context.Context, cav string) bool {
+	name, arg, err := ParseCaveat(cav)
+	if err != nil {
+		return true
+	}
+	e, err := c.lookup(ctx, name)
+	if err != nil || e.dynamic {
+		return true
+	}
+	if name != CondExpr {
+		return false
+	}
+	expr, err := ParseExpr(arg)
+	if err != nil {
+		return true
+	}
+	return c.isExprDynamic(ctx, expr)
+}
+
+// CheckFunc returns a function that checks caveats in the given
+// context, suitable for passing to Macaroon.Verify. It is safe
+// to call concurrently.
+func (c *Checker) CheckFunc(ctx context.Context) func(caveat string) error {
+	ctx = contextWithBodyHash(ctx)
+	return func(cav string) error {
+		return c.CheckFirstPartyCaveat(ctx, cav)
+	}
+}
+
+// Verify verifies the macaroons in s, which must already have been
+// bound, checking first party caveats with c. The attributes declared
+// by the macaroons (see InferDeclared) are made available to the
+// checker functions in the context, and are returned on success.
+// The namespace prefix in a condition is resolved using the namespace
+// caveats before it in the same macaroon (see NamespaceCaveat), or,
+// if they do not declare it, the checker's own namespace.
+// Once the macaroons have been verified, a use is recorded
+// for each uses caveat (see UsesCaveat).
+//
+// If opts.Cache is set, the dynamic conditions (see IsDynamic) are
+// checked again on a cache hit, as are any conditions for which
+// opts.Dynamic returns true.
+func (c *Checker) Verify(ctx context.Context, rootKey []byte, s Slice, opts *VerifyOptions) (map[string]string, error) {
+	if len(s) == 0 {
+		return nil, fmt.Errorf("no macaroons in slice")
+	}
+	declared, err := InferDeclared(s)
+	if err != nil {
+		return nil, err
+	}
+	ctx = ContextWithDeclared(ctx, declared)
+	// Ignore any namespace already in the context, so that
+	// prefixes not declared by a macaroon always resolve
+	// with the checker's own namespace.
+	ctx = ContextWithNamespace(ctx, nil)
+	ctx = contextWithBodyHash(ctx)
+	var uses *pendingUses
+	if c.Usage != nil {
+		uses = new(pendingUses)
+		ctx = context.WithValue(ctx, usesKey{}, uses)
+	}
+	scopes, err := newNamespaceScopes(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+	cc := caveatChecker{
+		check: func(m *Macaroon, i int, cav string) error {
+			return c.CheckFirstPartyCaveat(scopes.context(ctx, m, i), cav)
+		},
+		// The dynamic function is only called on a cache
+		// hit, so opts is known to be non-nil.
+		dynamic: func(m *Macaroon, i int, cav string) bool {
+			if opts.Dynamic != nil && opts.Dynamic(cav) {
+				return true
+			}
+			return c.IsDynamic(scopes.context(ctx, m, i), cav)
+		},
+	}
+	if err := s[0].verifyWithOptions(rootKey, nil, &cc, s[1:], opts); err != nil {
+		return nil, err
+	}
+	if uses != nil {
+		if err := uses.commit(c.Usage); err != nil {
+			return nil, err
+		}
+	}
+	return declared, nil
+}
@@ -0,0 +1,137 @@
+package macaroon
+
+import (
+	"bytes"
+	"compress/flate"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Compressed data starts with two zero bytes followed by a byte
+// identifying the compression method. Uncompressed data always
+// starts with a packet, and a packet size of zero is invalid, so
+// the two forms cannot be confused.
+const compressedPrefixLen = 3
+
+const (
+	// compressDeflate identifies data compressed with
+	// deflate using deflateDict as a preset dictionary.
+	compressDeflate = 1
+)
+
+// deflateDict holds the preset dictionary used with compressDeflate.
+// It holds strings that are common in macaroons, with the most common
+// last so that they can be referred to with the shortest distances.
+// It must never change, as that would break existing data; a new
+// dictionary requires a new compression method.
+var deflateDict = []byte(strings.Join([]string{
+	"https://",
+	"http://",
+	CondNeedDeclared + " ",
+	CondExpr + " and(",
+	CondExpr + " or(",
+	CondExpr + " not(",
+	CondNamespace + " ",
+	CondClientIP + " ",
+	CondHTTPHost + " ",
+	CondHTTPMethod + " ",
+	CondHTTPPath + " /",
+	CondHolderKey + " ",
+	CondUses + " ",
+	CondTimeAfter + " ",
+	CondDeclared + " ",
+	CondTimeBefore + " 20",
+}, ""))
+
+// MarshalBinaryCompressed is like MarshalBinary except that the
+// data is compressed, which can make it considerably smaller when
+// the macaroon has long or repetitive caveats. UnmarshalBinary and
+// UnmarshalText recognize compressed data automatically.
+func (m *Macaroon) MarshalBinaryCompressed() ([]byte, error) {
+	data, err := m.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	return compress(data)
+}
+
+// MarshalTextCompressed is like MarshalText except that the
+// binary form is compressed as for MarshalBinaryCompressed.
+func (m *Macaroon) MarshalTextCompressed() ([]byte, error) {
+	data, err := m.MarshalBinaryCompressed()
+	if err != nil {
+		return nil, err
+	}
+	return encodeBase64(data), nil
+}
+
+// MarshalBinaryCompressed is like MarshalBinary except that the
+// data is compressed. See Macaroon.MarshalBinaryCompressed.
+func (s Slice) MarshalBinaryCompressed() ([]byte, error) {
+	data, err := s.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	return compress(data)
+}
+
+// MarshalTextCompressed is like MarshalText except that the
+// binary form is compressed as for MarshalBinaryCompressed.
+func (s Slice) MarshalTextCompressed() ([]byte, error) {
+	data, err := s.MarshalBinaryCompressed()
+	if err != nil {
+		return nil, err
+	}
+	return encodeBase64(data), nil
+}
+
+func compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.Write([]byte{0, 0, compressDeflate})
+	w, err := flate.NewWriterDict(&buf, flate.BestCompression, deflateDict)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, fmt.Errorf("cannot compress macaroon data: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		return nil, fmt.Errorf("cannot compress macaroon data: %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// isCompressed reports whether data starts
+// with the prefix of compressed data.
+func isCompressed(data []byte) bool {
+	return len(data) >= compressedPrefixLen && data[0] == 0 && data[1] == 0
+}
+
+// decodeData returns a copy of data that is owned by the caller,
+// decompressing it first if it is compressed. The size of the
+// decompressed data is checked against the MaxSize limit without
+// decompressing any more than that.
+func (l *Limits) decodeData(data []byte) ([]byte, error) {
+	if err := l.checkSize(len(data)); err != nil {
+		return nil, err
+	}
+	if !isCompressed(data) {
+		return append([]byte(nil), data...), nil
+	}
+	if method := data[2]; method != compressDeflate {
+		return nil, fmt.Errorf("unknown macaroon compression method %d", method)
+	}
+	var r io.Reader = flate.NewReaderDict(bytes.NewReader(data[compressedPrefixLen:]), deflateDict)
+	if l.MaxSize > 0 {
+		r = io.LimitReader(r, int64(l.MaxSize)+1)
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("cannot decompress macaroon data: %v", err)
+	}
+	if err := l.checkSize(len(data)); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
@@ -0,0 +1,136 @@
+package macaroon_test
+
+import (
+	"bytes"
+	"compress/flate"
+	"errors"
+	"strings"
+
+	gc "gopkg.in/check.v1"
+
+	"github.com/iron-io/macaroon"
+)
+
+type compressSuite struct{}
+
+var _ = gc.Suite(&compressSuite{})
+
+func (*compressSuite) TestMacaroonRoundTrip(c *gc.C) {
+	rootKey := []byte("secret")
+	m := MustNew(rootKey, "some id", "https://example.com")
+	for _, cav := range []string{
+		macaroon.DeclaredCaveat("username", "bob"),
+		macaroon.PathPrefixCaveat("/api/v1"),
+		macaroon.TimeBeforeCaveat(t0),
+	} {
+		err := m.AddFirstPartyCaveat(cav)
+		c.Assert(err, gc.IsNil)
+	}
+	data, err := m.MarshalBinaryCompressed()
+	c.Assert(err, gc.IsNil)
+	var m1 macaroon.Macaroon
+	err = m1.UnmarshalBinary(data)
+	c.Assert(err, gc.IsNil)
+	assertEqualMacaroons(c, &m1, m)
+
+	text, err := m.MarshalTextCompressed()
+	c.Assert(err, gc.IsNil)
+	var m2 macaroon.Macaroon
+	err = m2.UnmarshalText(text)
+	c.Assert(err, gc.IsNil)
+	assertEqualMacaroons(c, &m2, m)
+
+	// Uncompressed data is still accepted.
+	var m3 macaroon.Macaroon
+	err = m3.UnmarshalBinary(mustMarshalBinary(m))
+	c.Assert(err, gc.IsNil)
+	assertEqualMacaroons(c, &m3, m)
+}
+
+func (*compressSuite) TestSliceRoundTrip(c *gc.C) {
+	rootKey := []byte("secret")
+	m := MustNew(rootKey, "some id", "")
+	s := macaroon.Slice{m}
+	for i := 0; i < 4; i++ {
+		cavId := "https://identity.example.com/caveats/" + strings.Repeat("long-caveat-id-", 20) + string(rune('a'+i))
+		err := m.AddThirdPartyCaveat([]byte(cavId), cavId, "https://identity.example.com")
+		c.Assert(err, gc.IsNil)
+		s = append(s, MustNew([]byte(cavId), cavId, "https://identity.example.com"))
+	}
+	s.Bind()
+
+	text, err := s.MarshalText()
+	c.Assert(err, gc.IsNil)
+	ctext, err := s.MarshalTextCompressed()
+	c.Assert(err, gc.IsNil)
+	c.Logf("uncompressed %d bytes; compressed %d bytes", len(text), len(ctext))
+	c.Assert(len(ctext) < len(text)/2, gc.Equals, true)
+
+	var s1 macaroon.Slice
+	err = s1.UnmarshalText(ctext)
+	c.Assert(err, gc.IsNil)
+	c.Assert(s1, gc.HasLen, len(s))
+	for i := range s {
+		assertEqualMacaroons(c, s1[i], s[i])
+	}
+	c.Assert(s1.Verify(rootKey, never), gc.IsNil)
+
+	data, err := s.MarshalBinaryCompressed()
+	c.Assert(err, gc.IsNil)
+	var s2 macaroon.Slice
+	err = s2.UnmarshalBinary(data)
+	c.Assert(err, gc.IsNil)
+	c.Assert(s2, gc.HasLen, len(s))
+}
+
+// deflated returns data compressed in the
+// form produced by MarshalBinaryCompressed.
+func deflated(c *gc.C, data []byte) []byte {
+	var buf bytes.Buffer
+	buf.Write([]byte{0, 0, 1})
+	w, err := flate.NewWriter(&buf, flate.BestCompression)
+	c.Assert(err, gc.IsNil)
+	_, err = w.Write(data)
+	c.Assert(err, gc.IsNil)
+	c.Assert(w.Close(), gc.IsNil)
+	return buf.Bytes()
+}
+
+func (*compressSuite) TestDecompressionLimit(c *gc.C) {
+	bomb := deflated(c, make([]byte, 100*1024*1024))
+	c.Assert(len(bomb) < macaroon.DefaultLimits.MaxSize, gc.Equals, true)
+
+	var m macaroon.Macaroon
+	err := m.UnmarshalBinary(bomb)
+	c.Assert(err, gc.ErrorMatches, `macaroon limit exceeded: MaxSize is 1048576`)
+	var lerr *macaroon.LimitError
+	c.Assert(errors.As(err, &lerr), gc.Equals, true)
+
+	var s macaroon.Slice
+	err = s.UnmarshalBinary(bomb)
+	c.Assert(err, gc.ErrorMatches, `macaroon limit exceeded: MaxSize is 1048576`)
+
+	// The limit applies to the decompressed size.
+	m1 := MustNew([]byte("secret"), strings.Repeat("x", 1000), "")
+	data, err := m1.MarshalBinaryCompressed()
+	c.Assert(err, gc.IsNil)
+	limits := macaroon.Limits{MaxSize: 500}
+	c.Assert(len(data) < limits.MaxSize, gc.Equals, true)
+	err = limits.DecodeBinary(&m, data)
+	c.Assert(err, gc.ErrorMatches, `macaroon limit exceeded: MaxSize is 500`)
+	err = limits.DecodeSlice(&s, data)
+	c.Assert(err, gc.ErrorMatches, `macaroon limit exceeded: MaxSize is 500`)
+}
+
+func (*compressSuite) TestDecompressionErrors(c *gc.C) {
+	var m macaroon.Macaroon
+	err := m.UnmarshalBinary([]byte{0, 0, 99, 1, 2, 3})
+	c.Assert(err, gc.ErrorMatches, `unknown macaroon compression method 99`)
+
+	err = m.UnmarshalBinary([]byte{0, 0, 1, 0xff, 0xff, 0xff})
+	c.Assert(err, gc.ErrorMatches, `cannot decompress macaroon data: .*`)
+
+	// Compressed data that does not hold a macaroon.
+	err = m.UnmarshalBinary(deflated(c, []byte("hello")))
+	c.Assert(err, gc.ErrorMatches, `packet size too big`)
+}
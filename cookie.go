@@ -0,0 +1,190 @@
+package macaroon
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// CookieNamePrefix holds the prefix of the names
+// of cookies created by NewCookies.
+const CookieNamePrefix = "macaroon-"
+
+const (
+	// maxCookieValueLen holds the maximum length of the value of
+	// a single cookie created by NewCookies. Browsers limit the
+	// size of a cookie, including its name and attributes, to
+	// around 4096 bytes.
+	maxCookieValueLen = 3800
+
+	// maxCookieParts holds the maximum number of cookies
+	// that a Slice will be split across.
+	maxCookieParts = 64
+)
+
+// CookieName returns the name of the cookie holding a Slice
+// with the given primary macaroon. It is derived from the
+// primary's fingerprint, so Slices with different primaries
+// can be held in cookies at the same time.
+func CookieName(m *Macaroon) string {
+	return CookieNamePrefix + m.Fingerprint().String()
+}
+
+// NewCookies returns cookies holding s, which must not be empty.
+//
+// If the encoded Slice is too large for a single cookie, it is split
+// across several: the first has the name returned by CookieName, and
+// the rest have that name followed by "-1", "-2" and so on.
+//
+// The cookies are HttpOnly. Their path is taken from the most
+// specific http-path caveat in the primary macaroon (see
+// PathPrefixCaveat), or "/" if there is none, and they expire
+// when s does (see Slice.Expiry). Other attributes, such as
+// Domain and Secure, may be set by the caller.
+func NewCookies(s Slice) ([]*http.Cookie, error) {
+	if len(s) == 0 {
+		return nil, fmt.Errorf("no macaroons in slice")
+	}
+	text, err := s.MarshalText()
+	if err != nil {
+		return nil, err
+	}
+	var values []string
+	if len(text) <= maxCookieValueLen {
+		values = []string{string(text)}
+	} else {
+		// The encoding never contains a dot, so we can use one
+		// to prefix the first part with the number of parts.
+		n := (len(text) + maxCookieValueLen - 1) / maxCookieValueLen
+		if n > maxCookieParts {
+			return nil, fmt.Errorf("macaroons too large to store in cookies")
+		}
+		for rest := text; len(rest) > 0; {
+			part := rest
+			if len(part) > maxCookieValueLen {
+				part = part[0:maxCookieValueLen]
+			}
+			values = append(values, string(part))
+			rest = rest[len(part):]
+		}
+		values[0] = strconv.Itoa(n) + "." + values[0]
+	}
+	name := CookieName(s[0])
+	path := cookiePath(s[0])
+	expiry, _ := s.Expiry()
+	cookies := make([]*http.Cookie, len(values))
+	for i, value := range values {
+		cookies[i] = &http.Cookie{
+			Name:     cookiePartName(name, i),
+			Value:    value,
+			Path:     path,
+			Expires:  expiry,
+			HttpOnly: true,
+		}
+	}
+	return cookies, nil
+}
+
+func cookiePartName(name string, i int) string {
+	if i == 0 {
+		return name
+	}
+	return name + "-" + strconv.Itoa(i)
+}
+
+// cookiePath returns the longest path in any http-path
+// caveat in m, or "/" if there is none.
+func cookiePath(m *Macaroon) string {
+	p := "/"
+	for _, cav := range m.caveats {
+		if cav.isThirdParty() {
+			continue
+		}
+		name, arg, err := ParseCaveat(m.dataStr(cav.caveatId))
+		if err != nil || name != CondHTTPPath {
+			continue
+		}
+		if arg = cleanPath(arg); len(arg) > len(p) {
+			p = arg
+		}
+	}
+	return p
+}
+
+// SliceFromCookies returns the Slice held in the cookies in req
+// with the given name, as created by NewCookies. The discharge
+// macaroons in the returned Slice are already bound.
+func SliceFromCookies(req *http.Request, name string) (Slice, error) {
+	c, err := req.Cookie(name)
+	if err != nil {
+		return nil, fmt.Errorf("cannot find macaroon cookie %q", name)
+	}
+	text := c.Value
+	if i := strings.IndexByte(text, '.'); i >= 0 {
+		n, err := strconv.Atoi(text[0:i])
+		if err != nil || n < 2 || n > maxCookieParts {
+			return nil, fmt.Errorf("invalid macaroon cookie %q", name)
+		}
+		var buf strings.Builder
+		buf.WriteString(text[i+1:])
+		for i := 1; i < n; i++ {
+			partName := cookiePartName(name, i)
+			c, err := req.Cookie(partName)
+			if err != nil {
+				return nil, fmt.Errorf("cannot find macaroon cookie %q", partName)
+			}
+			buf.WriteString(c.Value)
+		}
+		text = buf.String()
+	}
+	var s Slice
+	if err := s.UnmarshalText([]byte(text)); err != nil {
+		return nil, fmt.Errorf("cannot decode macaroon cookie %q: %v", name, err)
+	}
+	if len(s) == 0 || CookieName(s[0]) != name {
+		return nil, fmt.Errorf("macaroon cookie %q does not match its contents", name)
+	}
+	return s, nil
+}
+
+// SlicesFromCookies returns all the Slices held in cookies in
+// req that were created by NewCookies, in the order in which
+// they appear in the request. The result is suitable for
+// passing to Oven.Authorize.
+//
+// Cookies that cannot be decoded, such as stale ones or ones
+// set by another site sharing the same domain, are ignored,
+// so that they do not prevent the other Slices from being used.
+func SlicesFromCookies(req *http.Request) []Slice {
+	var slices []Slice
+	for _, c := range req.Cookies() {
+		if !isCookieName(c.Name) {
+			continue
+		}
+		s, err := SliceFromCookies(req, c.Name)
+		if err != nil {
+			continue
+		}
+		slices = append(slices, s)
+	}
+	return slices
+}
+
+// isCookieName reports whether name could have been
+// returned by CookieName.
+func isCookieName(name string) bool {
+	if !strings.HasPrefix(name, CookieNamePrefix) {
+		return false
+	}
+	fp := name[len(CookieNamePrefix):]
+	if len(fp) != 2*len(Fingerprint{}) {
+		return false
+	}
+	for i := 0; i < len(fp); i++ {
+		if !('0' <= fp[i] && fp[i] <= '9' || 'a' <= fp[i] && fp[i] <= 'f') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,150 @@
+package macaroon_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	gc "gopkg.in/check.v1"
+
+	"github.com/iron-io/macaroon"
+)
+
+type cookieSuite struct{}
+
+var _ = gc.Suite(&cookieSuite{})
+
+func requestWithCookies(cookies []*http.Cookie) *http.Request {
+	req := httptest.NewRequest("GET", "/", nil)
+	for _, c := range cookies {
+		req.AddCookie(c)
+	}
+	return req
+}
+
+func (*cookieSuite) TestSingleCookie(c *gc.C) {
+	rootKey := []byte("secret")
+	expiry := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+	m := MustNew(rootKey, "some id", "")
+	for _, cav := range []string{
+		macaroon.PathPrefixCaveat("/api"),
+		macaroon.TimeBeforeCaveat(expiry.Add(time.Hour)),
+		macaroon.PathPrefixCaveat("/api/v1/"),
+		macaroon.TimeBeforeCaveat(expiry),
+	} {
+		err := m.AddFirstPartyCaveat(cav)
+		c.Assert(err, gc.IsNil)
+	}
+	cookies, err := macaroon.NewCookies(macaroon.Slice{m})
+	c.Assert(err, gc.IsNil)
+	c.Assert(cookies, gc.HasLen, 1)
+	c.Assert(cookies[0].Name, gc.Equals, "macaroon-"+m.Fingerprint().String())
+	c.Assert(cookies[0].Name, gc.Equals, macaroon.CookieName(m))
+	c.Assert(cookies[0].Path, gc.Equals, "/api/v1")
+	c.Assert(cookies[0].Expires.Equal(expiry), gc.Equals, true)
+	c.Assert(cookies[0].HttpOnly, gc.Equals, true)
+	c.Assert(cookies[0].Valid(), gc.IsNil)
+
+	s, err := macaroon.SliceFromCookies(requestWithCookies(cookies), cookies[0].Name)
+	c.Assert(err, gc.IsNil)
+	c.Assert(s, gc.HasLen, 1)
+	assertEqualMacaroons(c, s[0], m)
+}
+
+func (*cookieSuite) TestSplitCookies(c *gc.C) {
+	rootKey := []byte("secret")
+	m := MustNew(rootKey, "some id", "")
+	var discharges macaroon.Slice
+	for i := 0; i < 8; i++ {
+		cavId := strings.Repeat(string(rune('a'+i)), 1000)
+		key := []byte(cavId[0:1])
+		err := m.AddThirdPartyCaveat(key, cavId, "remote")
+		c.Assert(err, gc.IsNil)
+		discharges = append(discharges, MustNew(key, cavId, "remote"))
+	}
+	s := append(macaroon.Slice{m}, discharges...)
+	s.Bind()
+
+	cookies, err := macaroon.NewCookies(s)
+	c.Assert(err, gc.IsNil)
+	c.Assert(len(cookies) > 1, gc.Equals, true)
+	name := macaroon.CookieName(m)
+	for i, cookie := range cookies {
+		c.Assert(cookie.Valid(), gc.IsNil)
+		c.Assert(len(cookie.String()) < 4096, gc.Equals, true)
+		c.Assert(cookie.Path, gc.Equals, "/")
+		c.Assert(cookie.Expires.IsZero(), gc.Equals, true)
+		if i > 0 {
+			c.Assert(cookie.Name, gc.Equals, name+"-"+string(rune('0'+i)))
+		}
+	}
+	c.Assert(cookies[0].Name, gc.Equals, name)
+
+	s1, err := macaroon.SliceFromCookies(requestWithCookies(cookies), name)
+	c.Assert(err, gc.IsNil)
+	c.Assert(s1.Verify(rootKey, never), gc.IsNil)
+
+	// All the parts are required.
+	_, err = macaroon.SliceFromCookies(requestWithCookies(cookies[0:len(cookies)-1]), name)
+	c.Assert(err, gc.ErrorMatches, `cannot find macaroon cookie "`+name+`-[0-9]+"`)
+}
+
+func (*cookieSuite) TestSlicesFromCookies(c *gc.C) {
+	m1 := MustNew([]byte("key1"), "id1", "")
+	m2 := MustNew([]byte("key2"), "id2", "")
+	var cookies []*http.Cookie
+	for _, m := range []*macaroon.Macaroon{m1, m2} {
+		cs, err := macaroon.NewCookies(macaroon.Slice{m})
+		c.Assert(err, gc.IsNil)
+		cookies = append(cookies, cs...)
+	}
+	cookies = append(cookies, &http.Cookie{
+		Name:  "other",
+		Value: "something",
+	}, &http.Cookie{
+		// A malformed cookie with a macaroon cookie name
+		// does not prevent the others from being used.
+		Name:  macaroon.CookieNamePrefix + strings.Repeat("0", 32),
+		Value: "bad",
+	}, &http.Cookie{
+		// Nor does a cookie with a missing part.
+		Name:  macaroon.CookieNamePrefix + strings.Repeat("1", 32),
+		Value: "2.AAAA",
+	})
+	slices := macaroon.SlicesFromCookies(requestWithCookies(cookies))
+	c.Assert(slices, gc.HasLen, 2)
+	assertEqualMacaroons(c, slices[0][0], m1)
+	assertEqualMacaroons(c, slices[1][0], m2)
+
+	slices = macaroon.SlicesFromCookies(httptest.NewRequest("GET", "/", nil))
+	c.Assert(slices, gc.HasLen, 0)
+}
+
+func (*cookieSuite) TestCookieErrors(c *gc.C) {
+	m1 := MustNew([]byte("key1"), "id1", "")
+	m2 := MustNew([]byte("key2"), "id2", "")
+	cookies, err := macaroon.NewCookies(macaroon.Slice{m1})
+	c.Assert(err, gc.IsNil)
+	name := cookies[0].Name
+
+	_, err = macaroon.NewCookies(nil)
+	c.Assert(err, gc.ErrorMatches, `no macaroons in slice`)
+
+	req := httptest.NewRequest("GET", "/", nil)
+	_, err = macaroon.SliceFromCookies(req, name)
+	c.Assert(err, gc.ErrorMatches, `cannot find macaroon cookie "`+name+`"`)
+
+	// A cookie holding a different macaroon is rejected.
+	cookies[0].Name = macaroon.CookieName(m2)
+	_, err = macaroon.SliceFromCookies(requestWithCookies(cookies), cookies[0].Name)
+	c.Assert(err, gc.ErrorMatches, `macaroon cookie "`+cookies[0].Name+`" does not match its contents`)
+
+	cookies[0].Value = "99." + cookies[0].Value
+	_, err = macaroon.SliceFromCookies(requestWithCookies(cookies), cookies[0].Name)
+	c.Assert(err, gc.ErrorMatches, `invalid macaroon cookie ".*"`)
+
+	cookies[0].Value = "!!!"
+	_, err = macaroon.SliceFromCookies(requestWithCookies(cookies), cookies[0].Name)
+	c.Assert(err, gc.ErrorMatches, `cannot decode macaroon cookie ".*": cannot decode base64 macaroon data: .*`)
+}
@@ -1,28 +1,125 @@
 package macaroon
 
 import (
-	"crypto/hmac"
-	"crypto/sha1"
 	"crypto/sha256"
 	"fmt"
 	"hash"
 	"io"
+	"sync"
 
 	"golang.org/x/crypto/nacl/secretbox"
 )
 
+// keyedHash returns the HMAC-SHA256 of text using the given key.
 func keyedHash(key, text []byte) []byte {
-	h := keyedHasher(key)
-	h.Write([]byte(text))
-	return h.Sum(nil)
+	var sum [sha256.Size]byte
+	sc := getScratch()
+	sc.keyedHash(&sum, key, text)
+	putScratch(sc)
+	return sum[:]
 }
 
-func keyedHasher(key []byte) hash.Hash {
-	return hmac.New(sha1.New, key)
+// keyedHash2 hashes text1 and text2 separately with the given
+// key and then returns the hash of their concatenation. This
+// is how libmacaroons combines two values into a signature.
+func keyedHash2(key, text1, text2 []byte) []byte {
+	var sum [sha256.Size]byte
+	sc := getScratch()
+	sc.keyedHash2(&sum, key, text1, text2)
+	putScratch(sc)
+	return sum[:]
 }
 
-func makeKey(key []byte) *[keyLen]byte {
-	if len(key) < keyLen {
+// scratch holds the state and buffers needed to compute
+// HMAC-SHA256 without allocating. Unlike hmac.New, it
+// can be reused for different keys. Values are kept in
+// scratchPool so that they can be shared between calls.
+type scratch struct {
+	inner, outer hash.Hash
+	pad          [sha256.BlockSize]byte
+	innerSum     [sha256.Size]byte
+	hashedKey    [sha256.Size]byte
+	sums         [2 * sha256.Size]byte
+
+	// sig holds the running signature while a macaroon
+	// is being verified.
+	sig [sha256.Size]byte
+
+	// rootKey holds the key derived from the
+	// root key passed to Verify.
+	rootKey [sha256.Size]byte
+}
+
+var scratchPool = sync.Pool{
+	New: func() interface{} {
+		return &scratch{
+			inner: sha256.New(),
+			outer: sha256.New(),
+		}
+	},
+}
+
+func getScratch() *scratch {
+	return scratchPool.Get().(*scratch)
+}
+
+func putScratch(sc *scratch) {
+	scratchPool.Put(sc)
+}
+
+// keyedHash sets dst to the HMAC-SHA256 of text using the
+// given key, as described in RFC 2104. Both key and text
+// may refer to dst.
+func (sc *scratch) keyedHash(dst *[sha256.Size]byte, key, text []byte) {
+	if len(key) > sha256.BlockSize {
+		sc.inner.Reset()
+		sc.inner.Write(key)
+		key = sc.inner.Sum(sc.hashedKey[:0])
+	}
+	for i := range sc.pad {
+		sc.pad[i] = 0x36
+	}
+	for i, b := range key {
+		sc.pad[i] ^= b
+	}
+	sc.inner.Reset()
+	sc.inner.Write(sc.pad[:])
+	sc.inner.Write(text)
+	sc.inner.Sum(sc.innerSum[:0])
+	for i := range sc.pad {
+		sc.pad[i] ^= 0x36 ^ 0x5c
+	}
+	sc.outer.Reset()
+	sc.outer.Write(sc.pad[:])
+	sc.outer.Write(sc.innerSum[:])
+	sc.outer.Sum(dst[:0])
+}
+
+// keyedHash2 is the scratch equivalent of the keyedHash2 function.
+// Any of key, text1 and text2 may refer to dst.
+func (sc *scratch) keyedHash2(dst *[sha256.Size]byte, key, text1, text2 []byte) {
+	sum1 := (*[sha256.Size]byte)(sc.sums[0:sha256.Size])
+	sum2 := (*[sha256.Size]byte)(sc.sums[sha256.Size:])
+	sc.keyedHash(sum1, key, text1)
+	sc.keyedHash(sum2, key, text2)
+	sc.keyedHash(dst, key, sc.sums[:])
+}
+
+// keyGenerator is used to derive fixed length keys
+// from user-supplied root keys, as libmacaroons does.
+var keyGenerator = []byte("macaroons-key-generator")
+
+// makeKey derives the key used to sign a macaroon
+// from the given variable length root key.
+func makeKey(variableKey []byte) []byte {
+	return keyedHash(keyGenerator, variableKey)
+}
+
+// boxKey returns the given key in the form required
+// by secretbox. Macaroon signatures are exactly keyLen
+// bytes long and are used unchanged, as in libmacaroons.
+func boxKey(key []byte) *[keyLen]byte {
+	if len(key) <= keyLen {
 		var h [keyLen]byte
 		copy(h[:], key)
 		return &h
@@ -38,8 +135,7 @@ const (
 
 func newNonce(r io.Reader) (*[nonceLen]byte, error) {
 	var nonce [nonceLen]byte
-	_, err := r.Read(nonce[:])
-	if err != nil {
+	if _, err := io.ReadFull(r, nonce[:]); err != nil {
 		return nil, fmt.Errorf("cannot generate random bytes: %v", err)
 	}
 	return &nonce, nil
@@ -52,7 +148,7 @@ func encrypt(key, text []byte, r io.Reader) ([]byte, error) {
 	}
 	out := make([]byte, 0, len(nonce)+secretbox.Overhead+len(text))
 	out = append(out, nonce[:]...)
-	return secretbox.Seal(out, text, nonce, makeKey(key)), nil
+	return secretbox.Seal(out, text, nonce, boxKey(key)), nil
 }
 
 func decrypt(key, ciphertext []byte) ([]byte, error) {
@@ -62,7 +158,7 @@ func decrypt(key, ciphertext []byte) ([]byte, error) {
 	var nonce [nonceLen]byte
 	copy(nonce[:], ciphertext)
 	ciphertext = ciphertext[nonceLen:]
-	text, ok := secretbox.Open(nil, ciphertext, &nonce, makeKey(key))
+	text, ok := secretbox.Open(nil, ciphertext, &nonce, boxKey(key))
 	if !ok {
 		return nil, fmt.Errorf("decryption failure")
 	}
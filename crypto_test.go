@@ -1,8 +1,10 @@
 package macaroon
 
 import (
+	"bytes"
 	"crypto/rand"
 	"fmt"
+	"io"
 
 	"golang.org/x/crypto/nacl/secretbox"
 	gc "gopkg.in/check.v1"
@@ -46,6 +48,36 @@ func (*cryptoSuite) TestBadRandom(c *gc.C) {
 	c.Assert(err, gc.ErrorMatches, "^cannot generate random bytes:.*")
 }
 
+// shortReader returns at most one byte from
+// each Read call, and then io.EOF after n bytes.
+type shortReader struct {
+	n int
+}
+
+func (r *shortReader) Read(buf []byte) (int, error) {
+	if r.n == 0 {
+		return 0, io.EOF
+	}
+	if len(buf) == 0 {
+		return 0, nil
+	}
+	buf[0] = 0xff
+	r.n--
+	return 1, nil
+}
+
+func (*cryptoSuite) TestShortRead(c *gc.C) {
+	// Short reads are retried until the nonce is filled.
+	nonce, err := newNonce(&shortReader{n: nonceLen})
+	c.Assert(err, gc.IsNil)
+	c.Assert(nonce[:], gc.DeepEquals, bytes.Repeat([]byte{0xff}, nonceLen))
+
+	// Running out of data is an error rather than
+	// leaving some of the nonce zero.
+	_, err = newNonce(&shortReader{n: nonceLen - 1})
+	c.Assert(err, gc.ErrorMatches, "^cannot generate random bytes: unexpected EOF")
+}
+
 func (*cryptoSuite) TestBadCiphertext(c *gc.C) {
 	buf := randomBytes(nonceLen + secretbox.Overhead)
 	for i := range buf {
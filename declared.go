@@ -0,0 +1,177 @@
+package macaroon
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+const (
+	// CondDeclared is the condition name of
+	// caveats created by DeclaredCaveat.
+	CondDeclared = "declared"
+
+	// CondNeedDeclared is the condition name of
+	// third party caveat ids created by NeedDeclaredCaveat.
+	CondNeedDeclared = "need-declared"
+)
+
+// DeclaredCaveat returns a first party caveat that declares the
+// given attribute. A declared attribute is taken to hold for the
+// bearer of the macaroon; for example, a service might declare
+// the name of the user that the macaroon was issued to.
+// The key may not contain a space.
+func DeclaredCaveat(key, value string) string {
+	return Condition(CondDeclared, key+" "+value)
+}
+
+// NeedDeclaredCaveat returns a third party caveat id that asks the
+// third party to discharge the given condition and to declare the
+// given attributes in the discharge macaroon. Declarations in a
+// discharge macaroon are only trusted by InferDeclared when the
+// caveat it discharges asked for them in this way.
+func NeedDeclaredCaveat(keys []string, condition string) string {
+	return Condition(CondNeedDeclared, strings.Join(keys, ",")+" "+condition)
+}
+
+// ParseNeedDeclared parses a third party caveat id created
+// by NeedDeclaredCaveat. It is intended for use by third
+// parties.
+func ParseNeedDeclared(caveatId string) (keys []string, condition string, err error) {
+	name, arg, err := ParseCaveat(caveatId)
+	if err != nil {
+		return nil, "", err
+	}
+	if name != CondNeedDeclared {
+		return nil, "", fmt.Errorf("caveat %q is not a %s caveat", caveatId, CondNeedDeclared)
+	}
+	i := strings.IndexByte(arg, ' ')
+	if i <= 0 {
+		return nil, "", fmt.Errorf("%s caveat %q has no condition", CondNeedDeclared, caveatId)
+	}
+	keys = strings.Split(arg[0:i], ",")
+	for _, key := range keys {
+		if key == "" {
+			return nil, "", fmt.Errorf("empty key in %s caveat %q", CondNeedDeclared, caveatId)
+		}
+	}
+	return keys, arg[i+1:], nil
+}
+
+func parseDeclared(arg string) (key, value string, err error) {
+	i := strings.IndexByte(arg, ' ')
+	if i <= 0 {
+		return "", "", fmt.Errorf("declared caveat has no value")
+	}
+	return arg[0:i], arg[i+1:], nil
+}
+
+// InferDeclared returns the attributes declared by the macaroons in
+// s. All declarations in the primary macaroon are trusted. A
+// declaration in a discharge macaroon is trusted only if the third
+// party caveat that it discharges was created by NeedDeclaredCaveat
+// with the declared key, and, for a nested discharge, if every
+// enclosing discharge was also trusted with the key. Other
+// declarations are ignored.
+//
+// An error is returned if the same key is declared with
+// different values. Note that the declarations are only
+// valid once s has been verified.
+func InferDeclared(s Slice) (map[string]string, error) {
+	declared := make(map[string]string)
+	if len(s) == 0 {
+		return declared, nil
+	}
+	discharges := make(map[string]*Macaroon)
+	for i := len(s) - 1; i > 0; i-- {
+		discharges[s[i].Id()] = s[i]
+	}
+	visited := make(map[*Macaroon]bool)
+	var infer func(m *Macaroon, allowed func(key string) bool) error
+	infer = func(m *Macaroon, allowed func(key string) bool) error {
+		if visited[m] {
+			return nil
+		}
+		visited[m] = true
+		for _, cav := range m.caveats {
+			cavId := m.dataStr(cav.caveatId)
+			if cav.isThirdParty() {
+				dm := discharges[cavId]
+				if dm == nil {
+					continue
+				}
+				keys, _, err := ParseNeedDeclared(cavId)
+				if err != nil {
+					keys = nil
+				}
+				allowedInDischarge := func(key string) bool {
+					return allowed(key) && containsString(keys, key)
+				}
+				if err := infer(dm, allowedInDischarge); err != nil {
+					return err
+				}
+				continue
+			}
+			name, arg, err := ParseCaveat(cavId)
+			if err != nil || name != CondDeclared {
+				continue
+			}
+			key, value, err := parseDeclared(arg)
+			if err != nil || !allowed(key) {
+				continue
+			}
+			if old, ok := declared[key]; ok && old != value {
+				return fmt.Errorf("conflicting declarations of %q: %q and %q", key, old, value)
+			}
+			declared[key] = value
+		}
+		return nil
+	}
+	if err := infer(s[0], func(string) bool { return true }); err != nil {
+		return nil, err
+	}
+	return declared, nil
+}
+
+func containsString(ss []string, s string) bool {
+	for _, x := range ss {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
+
+type declaredKey struct{}
+
+// ContextWithDeclared returns a context holding the given declared
+// attributes, for use by the declared caveat checker.
+func ContextWithDeclared(ctx context.Context, declared map[string]string) context.Context {
+	return context.WithValue(ctx, declaredKey{}, declared)
+}
+
+// DeclaredFromContext returns the declared attributes
+// held in the context by ContextWithDeclared.
+func DeclaredFromContext(ctx context.Context) map[string]string {
+	declared, _ := ctx.Value(declaredKey{}).(map[string]string)
+	return declared
+}
+
+// checkDeclared checks a declared caveat against the
+// attributes held in the context. The attributes are
+// normally those inferred from the macaroons being
+// verified, so this fails only if a declaration was
+// not trusted.
+func checkDeclared(ctx context.Context, _, arg string) error {
+	key, value, err := parseDeclared(arg)
+	if err != nil {
+		return err
+	}
+	declared := DeclaredFromContext(ctx)
+	if got, ok := declared[key]; !ok {
+		return fmt.Errorf("got no value for %q, expected %q", key, value)
+	} else if got != value {
+		return fmt.Errorf("got %s=%q, expected %q", key, got, value)
+	}
+	return nil
+}
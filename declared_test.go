@@ -0,0 +1,172 @@
+package macaroon_test
+
+import (
+	"context"
+	"errors"
+
+	gc "gopkg.in/check.v1"
+
+	"github.com/iron-io/macaroon"
+)
+
+type declaredSuite struct{}
+
+var _ = gc.Suite(&declaredSuite{})
+
+// declaredSlice returns a bound slice holding a primary macaroon that
+// declares username, with a third party caveat asking for the given
+// keys to be declared, discharged by a macaroon declaring the
+// given attributes.
+func declaredSlice(c *gc.C, rootKey []byte, needKeys []string, dischargeAttrs map[string]string) macaroon.Slice {
+	m := MustNew(rootKey, "some id", "")
+	err := m.AddFirstPartyCaveat(macaroon.DeclaredCaveat("username", "bob"))
+	c.Assert(err, gc.IsNil)
+	cavId := "is-authenticated"
+	if needKeys != nil {
+		cavId = macaroon.NeedDeclaredCaveat(needKeys, cavId)
+	}
+	err = m.AddThirdPartyCaveat([]byte("third party key"), cavId, "remote")
+	c.Assert(err, gc.IsNil)
+	dm := MustNew([]byte("third party key"), cavId, "remote")
+	for _, key := range []string{"group", "username", "other"} {
+		if value, ok := dischargeAttrs[key]; ok {
+			err := dm.AddFirstPartyCaveat(macaroon.DeclaredCaveat(key, value))
+			c.Assert(err, gc.IsNil)
+		}
+	}
+	s := macaroon.Slice{m, dm}
+	s.Bind()
+	return s
+}
+
+var inferDeclaredTests = []struct {
+	about          string
+	needKeys       []string
+	dischargeAttrs map[string]string
+	expect         map[string]string
+	expectErr      string
+}{{
+	about:  "primary only",
+	expect: map[string]string{"username": "bob"},
+}, {
+	about:          "untrusted discharge declaration is ignored",
+	dischargeAttrs: map[string]string{"group": "admin"},
+	expect:         map[string]string{"username": "bob"},
+}, {
+	about:          "trusted discharge declaration",
+	needKeys:       []string{"group"},
+	dischargeAttrs: map[string]string{"group": "admin", "other": "x"},
+	expect:         map[string]string{"username": "bob", "group": "admin"},
+}, {
+	about:          "consistent declarations",
+	needKeys:       []string{"group", "username"},
+	dischargeAttrs: map[string]string{"username": "bob"},
+	expect:         map[string]string{"username": "bob"},
+}, {
+	about:          "conflicting declarations",
+	needKeys:       []string{"username"},
+	dischargeAttrs: map[string]string{"username": "alice"},
+	expectErr:      `conflicting declarations of "username": "bob" and "alice"`,
+}}
+
+func (*declaredSuite) TestInferDeclared(c *gc.C) {
+	for i, test := range inferDeclaredTests {
+		c.Logf("test %d: %s", i, test.about)
+		s := declaredSlice(c, []byte("secret"), test.needKeys, test.dischargeAttrs)
+		declared, err := macaroon.InferDeclared(s)
+		if test.expectErr != "" {
+			c.Assert(err, gc.ErrorMatches, test.expectErr)
+			continue
+		}
+		c.Assert(err, gc.IsNil)
+		c.Assert(declared, gc.DeepEquals, test.expect)
+	}
+}
+
+func (*declaredSuite) TestInferDeclaredNested(c *gc.C) {
+	// A nested discharge is trusted only with the keys
+	// that every enclosing caveat asked for.
+	rootKey := []byte("secret")
+	m := MustNew(rootKey, "some id", "")
+	cav1 := macaroon.NeedDeclaredCaveat([]string{"a", "b"}, "cond1")
+	err := m.AddThirdPartyCaveat([]byte("key1"), cav1, "remote1")
+	c.Assert(err, gc.IsNil)
+	dm1 := MustNew([]byte("key1"), cav1, "remote1")
+	cav2 := macaroon.NeedDeclaredCaveat([]string{"b", "c"}, "cond2")
+	err = dm1.AddThirdPartyCaveat([]byte("key2"), cav2, "remote2")
+	c.Assert(err, gc.IsNil)
+	dm2 := MustNew([]byte("key2"), cav2, "remote2")
+	for _, key := range []string{"a", "b", "c"} {
+		err = dm2.AddFirstPartyCaveat(macaroon.DeclaredCaveat(key, "v"))
+		c.Assert(err, gc.IsNil)
+	}
+	declared, err := macaroon.InferDeclared(macaroon.Slice{m, dm1, dm2})
+	c.Assert(err, gc.IsNil)
+	c.Assert(declared, gc.DeepEquals, map[string]string{"b": "v"})
+}
+
+func (*declaredSuite) TestParseNeedDeclared(c *gc.C) {
+	keys, cond, err := macaroon.ParseNeedDeclared(macaroon.NeedDeclaredCaveat([]string{"a", "b"}, "is-ok arg"))
+	c.Assert(err, gc.IsNil)
+	c.Assert(keys, gc.DeepEquals, []string{"a", "b"})
+	c.Assert(cond, gc.Equals, "is-ok arg")
+
+	_, _, err = macaroon.ParseNeedDeclared("other a cond")
+	c.Assert(err, gc.ErrorMatches, `caveat "other a cond" is not a need-declared caveat`)
+	_, _, err = macaroon.ParseNeedDeclared("need-declared a")
+	c.Assert(err, gc.ErrorMatches, `need-declared caveat "need-declared a" has no condition`)
+	_, _, err = macaroon.ParseNeedDeclared("need-declared a,,b cond")
+	c.Assert(err, gc.ErrorMatches, `empty key in need-declared caveat .*`)
+}
+
+func (*declaredSuite) TestCheckerVerify(c *gc.C) {
+	rootKey := []byte("secret")
+	checker := macaroon.NewChecker()
+	s := declaredSlice(c, rootKey, []string{"group"}, map[string]string{"group": "admin"})
+	declared, err := checker.Verify(context.Background(), rootKey, s, nil)
+	c.Assert(err, gc.IsNil)
+	c.Assert(declared, gc.DeepEquals, map[string]string{"username": "bob", "group": "admin"})
+
+	// A declaration that is not trusted causes
+	// verification to fail.
+	s = declaredSlice(c, rootKey, nil, map[string]string{"group": "admin"})
+	_, err = checker.Verify(context.Background(), rootKey, s, nil)
+	c.Assert(err, gc.ErrorMatches, `caveat "declared group admin" not satisfied: got no value for "group", expected "admin"`)
+
+	s = declaredSlice(c, rootKey, []string{"username"}, map[string]string{"username": "alice"})
+	_, err = checker.Verify(context.Background(), rootKey, s, nil)
+	c.Assert(err, gc.ErrorMatches, `conflicting declarations .*`)
+}
+
+func (*declaredSuite) TestChecker(c *gc.C) {
+	checker := macaroon.NewChecker()
+	checker.Register("is-even", func(ctx context.Context, name, arg string) error {
+		if arg != "2" {
+			return errors.New("not even")
+		}
+		return nil
+	})
+	c.Assert(func() {
+		checker.Register("is-even", nil)
+	}, gc.PanicMatches, `caveat condition "is-even" is already registered`)
+	c.Assert(func() {
+		checker.Register("a b", nil)
+	}, gc.PanicMatches, `invalid caveat condition name "a b"`)
+
+	ctx := context.Background()
+	err := checker.CheckFirstPartyCaveat(ctx, "is-even 2")
+	c.Assert(err, gc.IsNil)
+	err = checker.CheckFirstPartyCaveat(ctx, "is-even 3")
+	c.Assert(err, gc.ErrorMatches, `caveat "is-even 3" not satisfied: not even`)
+	err = checker.CheckFirstPartyCaveat(ctx, "unknown")
+	c.Assert(err, gc.ErrorMatches, `caveat "unknown" not satisfied: caveat not recognized`)
+	c.Assert(errors.Is(err, macaroon.ErrCaveatNotRecognized), gc.Equals, true)
+	err = checker.CheckFirstPartyCaveat(ctx, " x")
+	c.Assert(err, gc.ErrorMatches, `cannot parse caveat " x": caveat starts with space character`)
+
+	m := MustNew([]byte("key"), "id", "")
+	err = m.AddFirstPartyCaveat(macaroon.Condition("is-even", "2"))
+	c.Assert(err, gc.IsNil)
+	err = m.Verify([]byte("key"), checker.CheckFunc(ctx), nil)
+	c.Assert(err, gc.IsNil)
+}
@@ -0,0 +1,106 @@
+package macaroon
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Discharger acquires discharge macaroons for third party caveats.
+type Discharger interface {
+	// Discharge returns a discharge macaroon for the third party
+	// caveat with the given id and location. The returned
+	// macaroon must not be bound.
+	Discharge(ctx context.Context, cav Caveat) (*Macaroon, error)
+}
+
+// DischargeAll returns a Slice holding m followed by discharges,
+// acquired with d, for all the third party caveats in m and in the
+// acquired discharges in turn. The discharges are bound to m.
+func DischargeAll(ctx context.Context, m *Macaroon, d Discharger) (Slice, error) {
+	s := Slice{m}
+	for i := 0; i < len(s); i++ {
+		dm := s[i]
+		for _, cav := range dm.caveats {
+			if !cav.isThirdParty() {
+				continue
+			}
+			if err := DefaultLimits.checkDischarges(len(s)); err != nil {
+				return nil, err
+			}
+			tpc := Caveat{
+				Id:       dm.dataStr(cav.caveatId),
+				Location: dm.dataStr(cav.location),
+			}
+			discharge, err := d.Discharge(ctx, tpc)
+			if err != nil {
+				return nil, fmt.Errorf("cannot get discharge for caveat %q from %q: %v", tpc.Id, tpc.Location, err)
+			}
+			s = append(s, discharge)
+		}
+	}
+	s.Bind()
+	return s, nil
+}
+
+// DischargeResponse holds the JSON body of a successful response
+// from a discharge endpoint used by HTTPDischarger.
+type DischargeResponse struct {
+	Macaroon *Macaroon `json:"macaroon"`
+}
+
+// maxResponseSize holds the maximum size of a JSON response body
+// that will be read. It allows for the base64 and JSON encoding of
+// a macaroon of DefaultLimits.MaxSize bytes.
+const maxResponseSize = 4 * 1024 * 1024
+
+// HTTPDischarger is a Discharger that acquires discharges by making
+// an HTTP POST request to the "discharge" endpoint under the location
+// of each caveat, with the caveat id in the "id" form value. The
+// endpoint should respond with a DischargeResponse.
+type HTTPDischarger struct {
+	// Client holds the client used to make discharge
+	// requests. If it is nil, http.DefaultClient is used.
+	Client *http.Client
+}
+
+// Discharge implements Discharger.Discharge.
+func (d *HTTPDischarger) Discharge(ctx context.Context, cav Caveat) (*Macaroon, error) {
+	if cav.Location == "" {
+		return nil, fmt.Errorf("caveat has no location")
+	}
+	client := d.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	form := url.Values{"id": {cav.Id}}
+	req, err := http.NewRequestWithContext(ctx, "POST", strings.TrimSuffix(cav.Location, "/")+"/discharge", strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return nil, fmt.Errorf("cannot read discharge response: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("discharge request failed with status %q", resp.Status)
+	}
+	var dresp DischargeResponse
+	if err := json.Unmarshal(data, &dresp); err != nil {
+		return nil, fmt.Errorf("cannot unmarshal discharge response: %v", err)
+	}
+	if dresp.Macaroon == nil {
+		return nil, fmt.Errorf("no macaroon in discharge response")
+	}
+	return dresp.Macaroon, nil
+}
@@ -0,0 +1,302 @@
+package macaroon
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// CondExpr is the condition name of caveats created by ExprCaveat.
+//
+// The argument of an expr caveat is a composite condition with
+// the following grammar:
+//
+//	expr = and | or | not | leaf
+//	and  = "and(" expr "," expr { "," expr } ")"
+//	or   = "or(" expr "," expr { "," expr } ")"
+//	not  = "not(" expr ")"
+//	leaf = a first party caveat as a Go string literal (see strconv.Quote)
+//
+// There is no white space between tokens, and each leaf must be
+// quoted exactly as strconv.Quote would quote it, so every
+// expression has exactly one encoding. Leaves may not themselves
+// be expr caveats.
+//
+// All the conditions in an expression must be recognized by the
+// checker, but otherwise a not expression is satisfied whenever
+// checking its operand fails, so it should only be used with
+// conditions whose checkers fail just when the condition is false.
+const CondExpr = "expr"
+
+// Bounds on the size of expressions accepted by the expr checker.
+const (
+	maxExprSize   = 4096
+	maxExprDepth  = 8
+	maxExprLeaves = 32
+)
+
+type exprOp int
+
+const (
+	exprLeaf exprOp = iota
+	exprAnd
+	exprOr
+	exprNot
+)
+
+var exprOpNames = [...]string{
+	exprAnd: "and",
+	exprOr:  "or",
+	exprNot: "not",
+}
+
+// Expr holds a composite caveat condition.
+// It is created with Leaf, And, Or and Not.
+type Expr struct {
+	op   exprOp
+	leaf string
+	args []Expr
+}
+
+// Leaf returns an expression that is satisfied when the
+// given first party caveat is satisfied.
+func Leaf(caveat string) Expr {
+	return Expr{
+		op:   exprLeaf,
+		leaf: caveat,
+	}
+}
+
+// And returns an expression that is satisfied when all
+// the given expressions are satisfied.
+func And(exprs ...Expr) Expr {
+	return Expr{
+		op:   exprAnd,
+		args: exprs,
+	}
+}
+
+// Or returns an expression that is satisfied when any
+// of the given expressions is satisfied.
+func Or(exprs ...Expr) Expr {
+	return Expr{
+		op:   exprOr,
+		args: exprs,
+	}
+}
+
+// Not returns an expression that is satisfied
+// when e is not satisfied.
+func Not(e Expr) Expr {
+	return Expr{
+		op:   exprNot,
+		args: []Expr{e},
+	}
+}
+
+// String returns the canonical encoding of e.
+func (e Expr) String() string {
+	var buf strings.Builder
+	e.write(&buf)
+	return buf.String()
+}
+
+func (e Expr) write(buf *strings.Builder) {
+	if e.op == exprLeaf {
+		buf.WriteString(strconv.Quote(e.leaf))
+		return
+	}
+	buf.WriteString(exprOpNames[e.op])
+	buf.WriteByte('(')
+	for i, arg := range e.args {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		arg.write(buf)
+	}
+	buf.WriteByte(')')
+}
+
+// ExprCaveat returns a first party caveat that is satisfied when
+// the given expression is satisfied. It returns an error if the
+// expression would not be accepted by ParseExpr.
+func ExprCaveat(e Expr) (string, error) {
+	s := e.String()
+	if _, err := ParseExpr(s); err != nil {
+		return "", err
+	}
+	return Condition(CondExpr, s), nil
+}
+
+// ParseExpr parses an expression in the canonical encoding
+// described in the CondExpr documentation. Expressions with
+// more than 4096 bytes, more than 32 leaves or nested more
+// than 8 deep are rejected.
+func ParseExpr(s string) (Expr, error) {
+	if len(s) > maxExprSize {
+		return Expr{}, fmt.Errorf("expression too long")
+	}
+	p := &exprParser{s: s}
+	e, err := p.parse(0)
+	if err != nil {
+		return Expr{}, fmt.Errorf("cannot parse expression: %v", err)
+	}
+	if p.pos != len(s) {
+		return Expr{}, fmt.Errorf("cannot parse expression: unexpected %q at offset %d", s[p.pos:], p.pos)
+	}
+	return e, nil
+}
+
+type exprParser struct {
+	s      string
+	pos    int
+	leaves int
+}
+
+func (p *exprParser) parse(depth int) (Expr, error) {
+	if depth > maxExprDepth {
+		return Expr{}, fmt.Errorf("expression nested too deeply")
+	}
+	rest := p.s[p.pos:]
+	if strings.HasPrefix(rest, `"`) {
+		return p.parseLeaf()
+	}
+	for op, name := range exprOpNames {
+		if name == "" || !strings.HasPrefix(rest, name+"(") {
+			continue
+		}
+		p.pos += len(name) + 1
+		e := Expr{
+			op: exprOp(op),
+		}
+		for {
+			arg, err := p.parse(depth + 1)
+			if err != nil {
+				return Expr{}, err
+			}
+			e.args = append(e.args, arg)
+			if p.pos >= len(p.s) {
+				return Expr{}, fmt.Errorf("missing closing parenthesis")
+			}
+			c := p.s[p.pos]
+			p.pos++
+			if c == ')' {
+				break
+			}
+			if c != ',' {
+				return Expr{}, fmt.Errorf("unexpected %q at offset %d", c, p.pos-1)
+			}
+		}
+		if e.op == exprNot && len(e.args) != 1 {
+			return Expr{}, fmt.Errorf("not takes exactly one operand")
+		}
+		if e.op != exprNot && len(e.args) < 2 {
+			return Expr{}, fmt.Errorf("%s needs at least two operands", name)
+		}
+		return e, nil
+	}
+	return Expr{}, fmt.Errorf("unexpected %q at offset %d", rest, p.pos)
+}
+
+func (p *exprParser) parseLeaf() (Expr, error) {
+	if p.leaves++; p.leaves > maxExprLeaves {
+		return Expr{}, fmt.Errorf("too many conditions in expression")
+	}
+	quoted, err := strconv.QuotedPrefix(p.s[p.pos:])
+	if err != nil {
+		return Expr{}, fmt.Errorf("bad quoted condition at offset %d", p.pos)
+	}
+	leaf, err := strconv.Unquote(quoted)
+	if err != nil || strconv.Quote(leaf) != quoted {
+		return Expr{}, fmt.Errorf("non-canonical quoted condition %s", quoted)
+	}
+	name, _, err := ParseCaveat(leaf)
+	if err != nil {
+		return Expr{}, fmt.Errorf("bad condition %s: %v", quoted, err)
+	}
+	if name == CondExpr {
+		return Expr{}, fmt.Errorf("nested %s condition %s", CondExpr, quoted)
+	}
+	p.pos += len(quoted)
+	return Leaf(leaf), nil
+}
+
+// checkExpr checks an expr caveat by checking its
+// leaves with c.
+func (c *Checker) checkExpr(ctx context.Context, _, arg string) error {
+	e, err := ParseExpr(arg)
+	if err != nil {
+		return err
+	}
+	// Check that all the conditions are recognized before
+	// evaluating anything, so that an unknown condition
+	// cannot satisfy a not expression.
+	if err := c.checkExprRecognized(ctx, e); err != nil {
+		return err
+	}
+	return c.evalExpr(ctx, e)
+}
+
+func (c *Checker) checkExprRecognized(ctx context.Context, e Expr) error {
+	if e.op == exprLeaf {
+		name, _, _ := ParseCaveat(e.leaf)
+		if _, err := c.lookup(ctx, name); err != nil {
+			return fmt.Errorf("condition %q: %w", e.leaf, err)
+		}
+		return nil
+	}
+	for _, arg := range e.args {
+		if err := c.checkExprRecognized(ctx, arg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isExprDynamic reports whether any of
+// the conditions in e are dynamic.
+func (c *Checker) isExprDynamic(ctx context.Context, e Expr) bool {
+	if e.op == exprLeaf {
+		return c.IsDynamic(ctx, e.leaf)
+	}
+	for _, arg := range e.args {
+		if c.isExprDynamic(ctx, arg) {
+			return true
+		}
+	}
+	return false
+}
+
+// evalExpr returns nil if e is satisfied.
+func (c *Checker) evalExpr(ctx context.Context, e Expr) error {
+	switch e.op {
+	case exprLeaf:
+		return c.CheckFirstPartyCaveat(ctx, e.leaf)
+	case exprAnd:
+		for _, arg := range e.args {
+			if err := c.evalExpr(ctx, arg); err != nil {
+				return err
+			}
+		}
+		return nil
+	case exprOr:
+		var firstErr error
+		for _, arg := range e.args {
+			err := c.evalExpr(ctx, arg)
+			if err == nil {
+				return nil
+			}
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+		return fmt.Errorf("no alternative satisfied; first error: %w", firstErr)
+	case exprNot:
+		if err := c.evalExpr(ctx, e.args[0]); err == nil {
+			return fmt.Errorf("%s is satisfied", e.args[0])
+		}
+		return nil
+	}
+	panic("unreachable")
+}
@@ -0,0 +1,195 @@
+package macaroon_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	gc "gopkg.in/check.v1"
+
+	"github.com/iron-io/macaroon"
+)
+
+type exprSuite struct{}
+
+var _ = gc.Suite(&exprSuite{})
+
+// exprChecker returns a checker with an "is" condition
+// that is satisfied when its argument is "true".
+func exprChecker() *macaroon.Checker {
+	checker := macaroon.NewChecker()
+	checker.Register("is", func(ctx context.Context, name, arg string) error {
+		if arg != "true" {
+			return fmt.Errorf("%s is false", arg)
+		}
+		return nil
+	})
+	return checker
+}
+
+var (
+	yes = macaroon.Leaf("is true")
+	no  = macaroon.Leaf("is false")
+)
+
+var exprTests = []struct {
+	about     string
+	expr      macaroon.Expr
+	expect    string
+	expectErr string
+}{{
+	about:  "and",
+	expr:   macaroon.And(yes, yes),
+	expect: `and("is true","is true")`,
+}, {
+	about:     "and with false operand",
+	expr:      macaroon.And(yes, no, yes),
+	expectErr: `caveat "is false" not satisfied: false is false`,
+}, {
+	about:  "or",
+	expr:   macaroon.Or(no, yes),
+	expect: `or("is false","is true")`,
+}, {
+	about:     "or with all false",
+	expr:      macaroon.Or(no, no),
+	expectErr: `no alternative satisfied; first error: caveat "is false" not satisfied: false is false`,
+}, {
+	about:  "not",
+	expr:   macaroon.Not(no),
+	expect: `not("is false")`,
+}, {
+	about:     "not with true operand",
+	expr:      macaroon.Not(yes),
+	expectErr: `"is true" is satisfied`,
+}, {
+	about:  "nested",
+	expr:   macaroon.Or(macaroon.And(yes, no), macaroon.Not(macaroon.Or(no, no))),
+	expect: `or(and("is true","is false"),not(or("is false","is false")))`,
+}, {
+	about:  "quoted characters",
+	expr:   macaroon.And(macaroon.Leaf(`is "true"),("`), yes),
+	expect: `and("is \"true\"),(\"","is true")`,
+	// The first leaf has argument `"true"),("`.
+	expectErr: `caveat "is \\"true\\"\),\(\\"" not satisfied: .*`,
+}, {
+	about:     "unknown condition under not",
+	expr:      macaroon.Not(macaroon.Leaf("unknown")),
+	expectErr: `condition "unknown": caveat not recognized`,
+}}
+
+func (*exprSuite) TestExprCaveat(c *gc.C) {
+	checker := exprChecker()
+	for i, test := range exprTests {
+		c.Logf("test %d: %s", i, test.about)
+		cav, err := macaroon.ExprCaveat(test.expr)
+		c.Assert(err, gc.IsNil)
+		if test.expect != "" {
+			c.Assert(cav, gc.Equals, "expr "+test.expect)
+		}
+		e, err := macaroon.ParseExpr(strings.TrimPrefix(cav, "expr "))
+		c.Assert(err, gc.IsNil)
+		c.Assert(e, gc.DeepEquals, test.expr)
+
+		err = checker.CheckFirstPartyCaveat(context.Background(), cav)
+		if test.expectErr != "" {
+			c.Assert(err, gc.ErrorMatches, `caveat ".*" not satisfied: `+test.expectErr)
+		} else {
+			c.Assert(err, gc.IsNil)
+		}
+	}
+}
+
+func (*exprSuite) TestUnknownConditionIsNotRecognized(c *gc.C) {
+	cav, err := macaroon.ExprCaveat(macaroon.Or(yes, macaroon.Leaf("unknown")))
+	c.Assert(err, gc.IsNil)
+	err = exprChecker().CheckFirstPartyCaveat(context.Background(), cav)
+	c.Assert(errors.Is(err, macaroon.ErrCaveatNotRecognized), gc.Equals, true)
+}
+
+var parseExprErrorTests = []struct {
+	about     string
+	expr      string
+	expectErr string
+}{{
+	about:     "empty",
+	expr:      ``,
+	expectErr: `cannot parse expression: unexpected "" at offset 0`,
+}, {
+	about:     "unquoted leaf",
+	expr:      `and(is true,"is true")`,
+	expectErr: `cannot parse expression: unexpected "is true,.*" at offset 4`,
+}, {
+	about:     "white space",
+	expr:      `and("is true", "is true")`,
+	expectErr: `cannot parse expression: unexpected " .*" at offset 14`,
+}, {
+	about:     "non-canonical quoting",
+	expr:      `and("is \x74rue","is true")`,
+	expectErr: `cannot parse expression: non-canonical quoted condition "is .*x74rue"`,
+}, {
+	about:     "back-quoted leaf",
+	expr:      "and(`is true`,\"is true\")",
+	expectErr: "cannot parse expression: unexpected \"`is true`,.*\" at offset 4",
+}, {
+	about:     "single operand and",
+	expr:      `and("is true")`,
+	expectErr: `cannot parse expression: and needs at least two operands`,
+}, {
+	about:     "two operand not",
+	expr:      `not("is true","is false")`,
+	expectErr: `cannot parse expression: not takes exactly one operand`,
+}, {
+	about:     "missing parenthesis",
+	expr:      `and("is true","is true"`,
+	expectErr: `cannot parse expression: missing closing parenthesis`,
+}, {
+	about:     "trailing data",
+	expr:      `not("is true")x`,
+	expectErr: `cannot parse expression: unexpected "x" at offset 14`,
+}, {
+	about:     "unterminated leaf",
+	expr:      `not("is true)`,
+	expectErr: `cannot parse expression: bad quoted condition at offset 4`,
+}, {
+	about:     "nested expr condition",
+	expr:      `not("expr \"is true\"")`,
+	expectErr: `cannot parse expression: nested expr condition .*`,
+}, {
+	about:     "empty condition",
+	expr:      `not("")`,
+	expectErr: `cannot parse expression: bad condition "": empty caveat`,
+}, {
+	about:     "too deep",
+	expr:      strings.Repeat("not(", 9) + `"is true"` + strings.Repeat(")", 9),
+	expectErr: `cannot parse expression: expression nested too deeply`,
+}, {
+	about:     "too many leaves",
+	expr:      "and(" + strings.Repeat(`"is true",`, 32) + `"is true")`,
+	expectErr: `cannot parse expression: too many conditions in expression`,
+}, {
+	about:     "too long",
+	expr:      `not("is ` + strings.Repeat("x", 4096) + `")`,
+	expectErr: `expression too long`,
+}}
+
+func (*exprSuite) TestParseExprErrors(c *gc.C) {
+	for i, test := range parseExprErrorTests {
+		c.Logf("test %d: %s", i, test.about)
+		_, err := macaroon.ParseExpr(test.expr)
+		c.Assert(err, gc.ErrorMatches, test.expectErr)
+	}
+	_, err := macaroon.ExprCaveat(macaroon.And())
+	c.Assert(err, gc.ErrorMatches, `cannot parse expression: .*`)
+}
+
+func (*exprSuite) TestVerifyExprCaveat(c *gc.C) {
+	rootKey := []byte("secret")
+	m := MustNew(rootKey, "some id", "")
+	cav, err := macaroon.ExprCaveat(macaroon.Or(macaroon.Leaf(macaroon.DeclaredCaveat("username", "bob")), yes))
+	c.Assert(err, gc.IsNil)
+	err = m.AddFirstPartyCaveat(cav)
+	c.Assert(err, gc.IsNil)
+	_, err = exprChecker().Verify(context.Background(), rootKey, macaroon.Slice{m}, nil)
+	c.Assert(err, gc.IsNil)
+}
@@ -0,0 +1,83 @@
+package macaroon
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+)
+
+// Equal reports whether m and m1 hold the same macaroon: that is,
+// whether they have the same location, id, caveats and signature.
+// The signatures are compared in constant time.
+func (m *Macaroon) Equal(m1 *Macaroon) bool {
+	if m == nil || m1 == nil {
+		return m == m1
+	}
+	// Compare the signatures first so that the time taken
+	// does not depend on how much of the signature matches.
+	sigEqual := hmac.Equal(m.sig, m1.sig)
+	if !sigEqual || len(m.caveats) != len(m1.caveats) {
+		return false
+	}
+	if !bytes.Equal(m.dataBytes(m.id), m1.dataBytes(m1.id)) ||
+		!bytes.Equal(m.dataBytes(m.location), m1.dataBytes(m1.location)) {
+		return false
+	}
+	for i := range m.caveats {
+		cav, cav1 := &m.caveats[i], &m1.caveats[i]
+		if !bytes.Equal(m.dataBytes(cav.caveatId), m1.dataBytes(cav1.caveatId)) ||
+			!bytes.Equal(m.dataBytes(cav.verificationId), m1.dataBytes(cav1.verificationId)) ||
+			!bytes.Equal(m.dataBytes(cav.location), m1.dataBytes(cav1.location)) {
+			return false
+		}
+	}
+	return true
+}
+
+// Fingerprint holds a short digest of one or more macaroons.
+// It is suitable for use as a map key.
+type Fingerprint [16]byte
+
+// String returns the fingerprint in hexadecimal.
+func (f Fingerprint) String() string {
+	return hex.EncodeToString(f[:])
+}
+
+// Fingerprint returns a digest of the binary encoding of m.
+// Macaroons with the same fingerprint are Equal with
+// overwhelming probability.
+func (m *Macaroon) Fingerprint() Fingerprint {
+	h := sha256.New()
+	m.writeBinary(h)
+	return sumFingerprint(h)
+}
+
+// Fingerprint returns a digest of the binary encoding of
+// all the macaroons in s, in order. The fingerprint of a
+// Slice holding a single macaroon is the same as the
+// fingerprint of the macaroon itself.
+func (s Slice) Fingerprint() Fingerprint {
+	h := sha256.New()
+	for _, m := range s {
+		m.writeBinary(h)
+	}
+	return sumFingerprint(h)
+}
+
+// writeBinary writes the binary encoding of m to h
+// without allocating.
+func (m *Macaroon) writeBinary(h hash.Hash) {
+	h.Write(m.data)
+	var hdr [3]byte
+	h.Write(append(appendSize(hdr[:0], packetSize(m.sig)), byte(fieldSignature)))
+	h.Write(m.sig)
+}
+
+func sumFingerprint(h hash.Hash) Fingerprint {
+	var sum [sha256.Size]byte
+	var f Fingerprint
+	copy(f[:], h.Sum(sum[:0]))
+	return f
+}
@@ -0,0 +1,72 @@
+package macaroon_test
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+
+	gc "gopkg.in/check.v1"
+
+	"github.com/iron-io/macaroon"
+)
+
+type fingerprintSuite struct{}
+
+var _ = gc.Suite(&fingerprintSuite{})
+
+func (*fingerprintSuite) TestEqual(c *gc.C) {
+	rootKey := []byte("secret")
+	s := thirdPartySlice(c, rootKey)
+	m := s[0]
+	c.Assert(m.Equal(m), gc.Equals, true)
+	c.Assert(m.Equal(m.Clone()), gc.Equals, true)
+	c.Assert(m.Equal(nil), gc.Equals, false)
+	c.Assert((*macaroon.Macaroon)(nil).Equal(nil), gc.Equals, true)
+
+	// Macaroons decoded from different encodings are equal.
+	data, err := json.Marshal(m)
+	c.Assert(err, gc.IsNil)
+	var m1 macaroon.Macaroon
+	err = json.Unmarshal(data, &m1)
+	c.Assert(err, gc.IsNil)
+	c.Assert(m.Equal(&m1), gc.Equals, true)
+
+	m2, err := m.Restrict("a caveat")
+	c.Assert(err, gc.IsNil)
+	c.Assert(m.Equal(m2), gc.Equals, false)
+
+	// Same signature but different content.
+	m3 := MustNew(rootKey, "other id", "a location")
+	m4 := MustNew(rootKey, "other id", "another location")
+	c.Assert(m3.Equal(m4), gc.Equals, false)
+
+	c.Assert(s[1].Equal(s[2]), gc.Equals, false)
+}
+
+func (*fingerprintSuite) TestFingerprint(c *gc.C) {
+	rootKey := []byte("secret")
+	s := thirdPartySlice(c, rootKey)
+	m := s[0]
+	data, err := m.MarshalBinary()
+	c.Assert(err, gc.IsNil)
+	sum := sha256.Sum256(data)
+	var expect macaroon.Fingerprint
+	copy(expect[:], sum[:])
+	c.Assert(m.Fingerprint(), gc.Equals, expect)
+	c.Assert(m.Fingerprint().String(), gc.HasLen, 32)
+
+	m1, err := m.Restrict("a caveat")
+	c.Assert(err, gc.IsNil)
+	c.Assert(m1.Fingerprint(), gc.Not(gc.Equals), m.Fingerprint())
+
+	data, err = s.MarshalBinary()
+	c.Assert(err, gc.IsNil)
+	sum = sha256.Sum256(data)
+	copy(expect[:], sum[:])
+	c.Assert(s.Fingerprint(), gc.Equals, expect)
+	c.Assert(macaroon.Slice{m}.Fingerprint(), gc.Equals, m.Fingerprint())
+
+	// Binding the discharges changes the fingerprint.
+	f := s.Fingerprint()
+	s.Bind()
+	c.Assert(s.Fingerprint(), gc.Not(gc.Equals), f)
+}
@@ -0,0 +1,100 @@
+package macaroon
+
+// Frozen holds a macaroon that cannot be changed. Unlike a *Macaroon,
+// a *Frozen may be shared freely between goroutines: the methods that
+// would mutate a Macaroon return a new Frozen value instead.
+type Frozen struct {
+	m *Macaroon
+}
+
+// Freeze returns an immutable copy of m. Later changes
+// to m do not affect the returned value.
+func (m *Macaroon) Freeze() *Frozen {
+	return &Frozen{m.deepCopy()}
+}
+
+// Macaroon returns a mutable copy of the frozen macaroon.
+func (f *Frozen) Macaroon() *Macaroon {
+	return f.m.deepCopy()
+}
+
+// Location returns the macaroon's location hint.
+func (f *Frozen) Location() string {
+	return f.m.Location()
+}
+
+// Id returns the id of the macaroon.
+func (f *Frozen) Id() string {
+	return f.m.Id()
+}
+
+// Signature returns a copy of the macaroon's signature.
+func (f *Frozen) Signature() []byte {
+	return f.m.Signature()
+}
+
+// Caveats returns the macaroon's caveats.
+func (f *Frozen) Caveats() []Caveat {
+	return f.m.Caveats()
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler.
+func (f *Frozen) MarshalBinary() ([]byte, error) {
+	return f.m.MarshalBinary()
+}
+
+// MarshalJSON implements json.Marshaler.
+func (f *Frozen) MarshalJSON() ([]byte, error) {
+	return f.m.MarshalJSON()
+}
+
+// WithFirstPartyCaveat returns a copy of the macaroon
+// with the given first party caveat added.
+func (f *Frozen) WithFirstPartyCaveat(caveatId string) (*Frozen, error) {
+	return f.Restrict(caveatId)
+}
+
+// WithThirdPartyCaveat returns a copy of the macaroon with the given
+// third party caveat added. See Macaroon.AddThirdPartyCaveat.
+func (f *Frozen) WithThirdPartyCaveat(rootKey []byte, caveatId string, loc string) (*Frozen, error) {
+	m := f.m.deepCopy()
+	if err := m.AddThirdPartyCaveat(rootKey, caveatId, loc); err != nil {
+		return nil, err
+	}
+	return &Frozen{m}, nil
+}
+
+// Restrict returns a copy of the macaroon with first party
+// caveats added for each of the given conditions.
+// See Macaroon.Restrict.
+func (f *Frozen) Restrict(conditions ...string) (*Frozen, error) {
+	m, err := f.m.Restrict(conditions...)
+	if err != nil {
+		return nil, err
+	}
+	return &Frozen{m}, nil
+}
+
+// Bind returns a copy of the macaroon bound to the macaroon
+// with the given signature. See Macaroon.Bind.
+func (f *Frozen) Bind(sig []byte) *Frozen {
+	m := f.m.deepCopy()
+	m.Bind(sig)
+	return &Frozen{m}
+}
+
+// Verify is like Macaroon.Verify.
+func (f *Frozen) Verify(rootKey []byte, check func(caveat string) error, discharges []*Frozen) error {
+	return f.VerifyWithOptions(rootKey, check, discharges, nil)
+}
+
+// VerifyWithOptions is like Macaroon.VerifyWithOptions.
+func (f *Frozen) VerifyWithOptions(rootKey []byte, check func(caveat string) error, discharges []*Frozen, opts *VerifyOptions) error {
+	// Verification never changes the macaroons, so
+	// there is no need to copy them.
+	ms := make([]*Macaroon, len(discharges))
+	for i, dm := range discharges {
+		ms[i] = dm.m
+	}
+	return f.m.VerifyWithOptions(rootKey, check, ms, opts)
+}
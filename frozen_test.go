@@ -0,0 +1,115 @@
+package macaroon_test
+
+import (
+	"fmt"
+	"sync"
+
+	gc "gopkg.in/check.v1"
+
+	"github.com/iron-io/macaroon"
+)
+
+type frozenSuite struct{}
+
+var _ = gc.Suite(&frozenSuite{})
+
+func (*frozenSuite) TestFreeze(c *gc.C) {
+	rootKey := []byte("secret")
+	m := MustNew(rootKey, "some id", "a location")
+	err := m.AddFirstPartyCaveat("a caveat")
+	c.Assert(err, gc.IsNil)
+	f := m.Freeze()
+
+	// Changing the original does not affect the frozen copy.
+	err = m.AddFirstPartyCaveat("another caveat")
+	c.Assert(err, gc.IsNil)
+	c.Assert(f.Id(), gc.Equals, "some id")
+	c.Assert(f.Location(), gc.Equals, "a location")
+	c.Assert(f.Caveats(), gc.DeepEquals, []macaroon.Caveat{{Id: "a caveat"}})
+	err = f.Verify(rootKey, allow("a caveat"), nil)
+	c.Assert(err, gc.IsNil)
+
+	// Nor does changing the value returned by Signature.
+	f.Signature()[0]++
+	err = f.Verify(rootKey, allow("a caveat"), nil)
+	c.Assert(err, gc.IsNil)
+
+	// Nor does changing a mutable copy.
+	m1 := f.Macaroon()
+	err = m1.AddFirstPartyCaveat("other")
+	c.Assert(err, gc.IsNil)
+	c.Assert(f.Caveats(), gc.HasLen, 1)
+
+	f1, err := f.WithFirstPartyCaveat("restricted")
+	c.Assert(err, gc.IsNil)
+	c.Assert(f.Caveats(), gc.HasLen, 1)
+	c.Assert(f1.Caveats(), gc.HasLen, 2)
+	err = f1.Verify(rootKey, allow("a caveat"), nil)
+	c.Assert(err, gc.ErrorMatches, `condition "restricted" not met`)
+}
+
+func (*frozenSuite) TestFrozenThirdPartyCaveat(c *gc.C) {
+	rootKey := []byte("secret")
+	f0 := MustNew(rootKey, "some id", "a location").Freeze()
+	f, err := f0.WithThirdPartyCaveat([]byte("shared root key"), "3rd party caveat", "remote.com")
+	c.Assert(err, gc.IsNil)
+	c.Assert(f0.Caveats(), gc.HasLen, 0)
+
+	dm := MustNew([]byte("shared root key"), "3rd party caveat", "remote.com").Freeze()
+	err = f.Verify(rootKey, never, []*macaroon.Frozen{dm.Bind(f.Signature())})
+	c.Assert(err, gc.IsNil)
+
+	// The unbound discharge has not changed.
+	err = f.Verify(rootKey, never, []*macaroon.Frozen{dm})
+	c.Assert(err, gc.ErrorMatches, `signature mismatch after caveat verification`)
+}
+
+func (*frozenSuite) TestConcurrentUse(c *gc.C) {
+	// This test is most useful when run with the race detector.
+	rootKey := []byte("secret")
+	m := MustNew(rootKey, "some id", "a location")
+	err := m.AddThirdPartyCaveat([]byte("shared root key"), "3rd party caveat", "remote.com")
+	c.Assert(err, gc.IsNil)
+	f := m.Freeze()
+	dm := MustNew([]byte("shared root key"), "3rd party caveat", "remote.com").Freeze()
+	bound := dm.Bind(f.Signature())
+	expectData, err := f.MarshalBinary()
+	c.Assert(err, gc.IsNil)
+
+	const n = 10
+	errs := make(chan error, 4*n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(4)
+		go func() {
+			defer wg.Done()
+			errs <- f.Verify(rootKey, never, []*macaroon.Frozen{bound})
+		}()
+		go func() {
+			defer wg.Done()
+			data, err := f.MarshalBinary()
+			if err == nil && string(data) != string(expectData) {
+				err = fmt.Errorf("unexpected marshaled data")
+			}
+			errs <- err
+		}()
+		go func() {
+			defer wg.Done()
+			f1, err := f.Restrict("restricted")
+			if err == nil {
+				err = f1.Verify(rootKey, allow("restricted"), []*macaroon.Frozen{dm.Bind(f1.Signature())})
+			}
+			errs <- err
+		}()
+		go func() {
+			defer wg.Done()
+			_, err := dm.Restrict("discharge restriction")
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		c.Check(err, gc.IsNil)
+	}
+}
@@ -0,0 +1,277 @@
+package macaroon
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+// fuzzMacaroons returns a primary macaroon with first and third
+// party caveats together with its bound discharge macaroons.
+// It is used both to seed the fuzzers and as the subject of FuzzVerify.
+func fuzzMacaroons() (rootKey []byte, primary *Macaroon, discharges []*Macaroon) {
+	rootKey = []byte("root-key")
+	primary = mustNew(rootKey, "root-id", "a location")
+	mustAddFirstPartyCaveat(primary, "wonderful")
+	if err := primary.AddThirdPartyCaveatWithRand([]byte("bob-key"), "bob-is-great", "bob", zeroReader{}); err != nil {
+		panic(err)
+	}
+	dm := mustNew([]byte("bob-key"), "bob-is-great", "bob")
+	mustAddFirstPartyCaveat(dm, "splendid")
+	dm.Bind(primary.Signature())
+	return rootKey, primary, []*Macaroon{dm}
+}
+
+func mustNew(rootKey []byte, id, loc string) *Macaroon {
+	m, err := New(rootKey, id, loc)
+	if err != nil {
+		panic(err)
+	}
+	return m
+}
+
+func mustAddFirstPartyCaveat(m *Macaroon, caveatId string) {
+	if err := m.AddFirstPartyCaveat(caveatId); err != nil {
+		panic(err)
+	}
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(buf []byte) (int, error) {
+	for i := range buf {
+		buf[i] = 0
+	}
+	return len(buf), nil
+}
+
+func FuzzParsePacket(f *testing.F) {
+	_, primary, _ := fuzzMacaroons()
+	f.Add(primary.data)
+	f.Add([]byte{})
+	f.Add([]byte{0, 0, 0})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		m := Macaroon{
+			data: data,
+		}
+		for start := 0; start < len(data); {
+			p, err := m.parsePacket(start)
+			if err != nil {
+				return
+			}
+			if p.len() == 0 {
+				t.Fatalf("zero length packet at %d", start)
+			}
+			// The packet should reproduce exactly when appended again.
+			data1, _, ok := rawAppendPacket(nil, m.fieldNum(p), m.dataBytes(p))
+			if !ok {
+				t.Fatalf("cannot append parsed packet")
+			}
+			if !bytes.Equal(data1, m.packetBytes(p)) {
+				t.Fatalf("packet mismatch; got %q want %q", data1, m.packetBytes(p))
+			}
+			start += p.len()
+		}
+	})
+}
+
+func FuzzUnmarshalBinary(f *testing.F) {
+	_, primary, discharges := fuzzMacaroons()
+	for _, m := range append([]*Macaroon{primary}, discharges...) {
+		data, err := m.MarshalBinary()
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(data)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var m Macaroon
+		if err := m.UnmarshalBinary(data); err != nil {
+			return
+		}
+		checkBinaryRoundTrip(t, &m)
+	})
+}
+
+func FuzzSliceUnmarshalBinary(f *testing.F) {
+	_, primary, discharges := fuzzMacaroons()
+	data, err := Slice(append([]*Macaroon{primary}, discharges...)).MarshalBinary()
+	if err != nil {
+		f.Fatal(err)
+	}
+	f.Add(data)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var s Slice
+		if err := s.UnmarshalBinary(data); err != nil {
+			return
+		}
+		data1, err := s.MarshalBinary()
+		if err != nil {
+			t.Fatalf("cannot marshal unmarshaled slice: %v", err)
+		}
+		var s1 Slice
+		if err := s1.UnmarshalBinary(data1); err != nil {
+			t.Fatalf("cannot unmarshal marshaled slice: %v", err)
+		}
+		data2, err := s1.MarshalBinary()
+		if err != nil {
+			t.Fatalf("cannot marshal slice: %v", err)
+		}
+		if !bytes.Equal(data1, data2) {
+			t.Fatalf("unstable round trip; got %q want %q", data2, data1)
+		}
+		for _, m := range s {
+			checkBinaryRoundTrip(t, m)
+		}
+	})
+}
+
+func FuzzUnmarshalJSON(f *testing.F) {
+	_, primary, discharges := fuzzMacaroons()
+	for _, m := range append([]*Macaroon{primary}, discharges...) {
+		data, err := json.Marshal(m)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(data)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var m Macaroon
+		if err := m.UnmarshalJSON(data); err != nil {
+			return
+		}
+		data1, err := m.MarshalJSON()
+		if err != nil {
+			t.Fatalf("cannot marshal unmarshaled macaroon: %v", err)
+		}
+		var m1 Macaroon
+		if err := m1.UnmarshalJSON(data1); err != nil {
+			t.Fatalf("cannot unmarshal marshaled macaroon: %v", err)
+		}
+		data2, err := m1.MarshalJSON()
+		if err != nil {
+			t.Fatalf("cannot marshal macaroon: %v", err)
+		}
+		if !bytes.Equal(data1, data2) {
+			t.Fatalf("unstable round trip; got %s want %s", data2, data1)
+		}
+		checkBinaryRoundTrip(t, &m)
+	})
+}
+
+// checkBinaryRoundTrip checks that m survives a binary round trip
+// unchanged.
+func checkBinaryRoundTrip(t *testing.T, m *Macaroon) {
+	data, err := m.MarshalBinary()
+	if err != nil {
+		t.Fatalf("cannot marshal macaroon: %v", err)
+	}
+	var m1 Macaroon
+	if err := m1.UnmarshalBinary(data); err != nil {
+		t.Fatalf("cannot unmarshal marshaled macaroon: %v", err)
+	}
+	if got, want := signedContent(&m1), signedContent(m); got != want {
+		t.Fatalf("round trip mismatch; got %s want %s", got, want)
+	}
+	data1, err := m1.MarshalBinary()
+	if err != nil {
+		t.Fatalf("cannot marshal macaroon: %v", err)
+	}
+	if !bytes.Equal(data, data1) {
+		t.Fatalf("unstable round trip; got %q want %q", data1, data)
+	}
+}
+
+// signedContent returns a representation of all the parts
+// of m that are covered by its signature, including
+// the signature itself. Locations are not included.
+func signedContent(m *Macaroon) string {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%q %x", m.Id(), m.sig)
+	for _, cav := range m.caveats {
+		fmt.Fprintf(&buf, " (%q %x)", m.dataBytes(cav.caveatId), m.dataBytes(cav.verificationId))
+	}
+	return buf.String()
+}
+
+func FuzzVerify(f *testing.F) {
+	rootKey, primary, discharges := fuzzMacaroons()
+	s := Slice(append([]*Macaroon{primary}, discharges...))
+	orig, err := s.MarshalBinary()
+	if err != nil {
+		f.Fatal(err)
+	}
+	origContent := make([]string, len(s))
+	for i, m := range s {
+		origContent[i] = signedContent(m)
+	}
+	check := func(cav string) error {
+		if cav == "wonderful" || cav == "splendid" {
+			return nil
+		}
+		return fmt.Errorf("condition %q not met", cav)
+	}
+	f.Add(uint16(0), byte(0))
+	f.Add(uint16(len(orig)-1), byte(1))
+	f.Add(uint16(primary.marshalBinaryLen()-1), byte(0x80))
+	f.Fuzz(func(t *testing.T, pos uint16, xor byte) {
+		data := append([]byte(nil), orig...)
+		data[int(pos)%len(data)] ^= xor
+		var s1 Slice
+		if err := s1.UnmarshalBinary(data); err != nil || len(s1) == 0 {
+			return
+		}
+		changed := len(s1) != len(s)
+		for i, m := range s1 {
+			if changed {
+				break
+			}
+			changed = signedContent(m) != origContent[i]
+		}
+		err := s1[0].Verify(rootKey, check, s1[1:])
+		if err == nil && changed {
+			t.Fatalf("verification succeeded on mutated macaroon (pos %d, xor %#x)", pos, xor)
+		}
+		if err != nil && !changed {
+			t.Fatalf("verification failed on unchanged macaroon: %v", err)
+		}
+	})
+}
+
+func FuzzUnmarshalV1(f *testing.F) {
+	fuzzUnmarshalLibmacaroons(f, (*Macaroon).MarshalV1, (*Macaroon).UnmarshalV1)
+}
+
+func FuzzUnmarshalV2(f *testing.F) {
+	fuzzUnmarshalLibmacaroons(f, (*Macaroon).MarshalV2, (*Macaroon).UnmarshalV2)
+}
+
+func fuzzUnmarshalLibmacaroons(f *testing.F, marshal func(*Macaroon) ([]byte, error), unmarshal func(*Macaroon, []byte) error) {
+	_, primary, discharges := fuzzMacaroons()
+	for _, m := range append([]*Macaroon{primary}, discharges...) {
+		data, err := marshal(m)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(data)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var m Macaroon
+		if err := unmarshal(&m, data); err != nil {
+			return
+		}
+		data1, err := marshal(&m)
+		if err != nil {
+			t.Fatalf("cannot marshal unmarshaled macaroon: %v", err)
+		}
+		var m1 Macaroon
+		if err := unmarshal(&m1, data1); err != nil {
+			t.Fatalf("cannot unmarshal marshaled macaroon: %v", err)
+		}
+		if got, want := signedContent(&m1), signedContent(&m); got != want {
+			t.Fatalf("round trip mismatch; got %s want %s", got, want)
+		}
+		checkBinaryRoundTrip(t, &m)
+	})
+}
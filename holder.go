@@ -0,0 +1,204 @@
+package macaroon
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// CondHolderKey is the condition name of
+// caveats created by HolderKeyCaveat.
+const CondHolderKey = "holder-key"
+
+const (
+	// HolderTimestampHeader holds the name of the HTTP header
+	// holding the time, in seconds since the Unix epoch, at
+	// which a request was signed by SignRequest.
+	HolderTimestampHeader = "Macaroon-Holder-Timestamp"
+
+	// HolderSignatureHeader holds the name of the HTTP header
+	// holding the signature added by SignRequest.
+	HolderSignatureHeader = "Macaroon-Holder-Signature"
+)
+
+// DefaultSignatureWindow holds the default value
+// of Checker.SignatureWindow.
+const DefaultSignatureWindow = 5 * time.Minute
+
+// DefaultMaxSignedBodySize holds the default value
+// of Checker.MaxSignedBodySize.
+const DefaultMaxSignedBodySize = 1024 * 1024
+
+// HolderKeyCaveat returns a first party caveat that binds a macaroon
+// to the holder of the private key corresponding to the given public
+// key. The caveat is satisfied only by HTTP requests that have been
+// signed with that key by SignRequest, so a macaroon with such a
+// caveat is useless to someone who has stolen just the macaroon.
+func HolderKeyCaveat(key ed25519.PublicKey) string {
+	return Condition(CondHolderKey, base64.RawURLEncoding.EncodeToString(key))
+}
+
+// SignRequest signs the given HTTP request with the given key, adding
+// the HolderTimestampHeader and HolderSignatureHeader headers. The
+// signature covers the method, the request URI (path and query), the
+// time and a hash of the body. The body is read in full, and replaced
+// so that the request can still be sent.
+func SignRequest(req *http.Request, key ed25519.PrivateKey) error {
+	bodyHash, err := hashRequestBody(req, -1)
+	if err != nil {
+		return err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	sig := ed25519.Sign(key, requestSigningData(req, timestamp, bodyHash))
+	req.Header.Set(HolderTimestampHeader, timestamp)
+	req.Header.Set(HolderSignatureHeader, base64.RawURLEncoding.EncodeToString(sig))
+	return nil
+}
+
+// hashRequestBody returns the SHA-256 hash of the
+// request body, replacing the body so that it can
+// be read again. If maxSize is non-negative and the
+// body is larger than that, it returns an error.
+func hashRequestBody(req *http.Request, maxSize int64) ([]byte, error) {
+	h := sha256.New()
+	if req.Body != nil && req.Body != http.NoBody {
+		r := io.Reader(req.Body)
+		if maxSize >= 0 {
+			r = io.LimitReader(r, maxSize+1)
+		}
+		body, err := io.ReadAll(r)
+		if err != nil {
+			req.Body.Close()
+			return nil, fmt.Errorf("cannot read request body: %v", err)
+		}
+		if maxSize >= 0 && int64(len(body)) > maxSize {
+			// Leave the body intact for the caller.
+			req.Body = struct {
+				io.Reader
+				io.Closer
+			}{io.MultiReader(bytes.NewReader(body), req.Body), req.Body}
+			return nil, fmt.Errorf("request body too large to check signature")
+		}
+		req.Body.Close()
+		req.Body = io.NopCloser(bytes.NewReader(body))
+		req.GetBody = func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(body)), nil
+		}
+		h.Write(body)
+	}
+	return h.Sum(nil), nil
+}
+
+// bodyHashKey is the context key for the *bodyHasher
+// added by Checker.Verify.
+type bodyHashKey struct{}
+
+// bodyHasher holds the hash of the body of a request. It is computed
+// at most once for each call to Checker.Verify, so that holder-key
+// caveats in different macaroons can be checked concurrently
+// without reading and replacing the body at the same time.
+type bodyHasher struct {
+	req  *http.Request
+	once sync.Once
+	hash []byte
+	err  error
+}
+
+// contextWithBodyHash returns a context that records the hash of
+// the body of the request in ctx, if any, once it has been computed.
+func contextWithBodyHash(ctx context.Context) context.Context {
+	req := RequestFromContext(ctx)
+	if req == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, bodyHashKey{}, &bodyHasher{req: req})
+}
+
+// requestBodyHash returns the hash of the body of req, reading
+// the body only if it has not already been hashed for ctx.
+func (c *Checker) requestBodyHash(ctx context.Context, req *http.Request) ([]byte, error) {
+	maxSize := c.MaxSignedBodySize
+	if maxSize == 0 {
+		maxSize = DefaultMaxSignedBodySize
+	}
+	h, _ := ctx.Value(bodyHashKey{}).(*bodyHasher)
+	if h == nil || h.req != req {
+		return hashRequestBody(req, maxSize)
+	}
+	h.once.Do(func() {
+		h.hash, h.err = hashRequestBody(req, maxSize)
+	})
+	return h.hash, h.err
+}
+
+// requestSigningData returns the data that
+// is signed for the given request.
+func requestSigningData(req *http.Request, timestamp string, bodyHash []byte) []byte {
+	method := req.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+	var buf bytes.Buffer
+	buf.WriteString("macaroon-holder-v1\n")
+	buf.WriteString(method)
+	buf.WriteByte('\n')
+	buf.WriteString(req.URL.RequestURI())
+	buf.WriteByte('\n')
+	buf.WriteString(timestamp)
+	buf.WriteByte('\n')
+	buf.WriteString(hex.EncodeToString(bodyHash))
+	return buf.Bytes()
+}
+
+// checkHolderKey checks that the request in the context has been
+// signed by the key in a holder-key caveat within the signature
+// window. The request body is read in full and replaced, once
+// for each call to Verify; it fails if the body is larger than
+// c.MaxSignedBodySize.
+func (c *Checker) checkHolderKey(ctx context.Context, _, arg string) error {
+	req, err := requestFromContext(ctx)
+	if err != nil {
+		return err
+	}
+	key, err := base64.RawURLEncoding.DecodeString(arg)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return fmt.Errorf("invalid holder key %q", arg)
+	}
+	timestamp := req.Header.Get(HolderTimestampHeader)
+	if timestamp == "" {
+		return fmt.Errorf("request is not signed")
+	}
+	secs, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid request signature timestamp %q", timestamp)
+	}
+	window := c.SignatureWindow
+	if window == 0 {
+		window = DefaultSignatureWindow
+	}
+	age := c.now().Sub(time.Unix(secs, 0))
+	if age > window+c.Skew || age < -c.Skew {
+		return fmt.Errorf("request signature timestamp out of range")
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(req.Header.Get(HolderSignatureHeader))
+	if err != nil {
+		return fmt.Errorf("invalid request signature")
+	}
+	bodyHash, err := c.requestBodyHash(ctx, req)
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(key, requestSigningData(req, timestamp, bodyHash), sig) {
+		return fmt.Errorf("request signature verification failed")
+	}
+	return nil
+}
@@ -0,0 +1,247 @@
+package macaroon_test
+
+import (
+	"context"
+	"crypto/ed25519"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	gc "gopkg.in/check.v1"
+
+	"github.com/iron-io/macaroon"
+)
+
+type holderSuite struct{}
+
+var _ = gc.Suite(&holderSuite{})
+
+func mustGenerateKey(c *gc.C) (ed25519.PublicKey, ed25519.PrivateKey) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	c.Assert(err, gc.IsNil)
+	return pub, priv
+}
+
+func (*holderSuite) TestSignedRequestOverHTTP(c *gc.C) {
+	pub, priv := mustGenerateKey(c)
+	rootKey := []byte("secret")
+	m := MustNew(rootKey, "some id", "")
+	err := m.AddFirstPartyCaveat(macaroon.HolderKeyCaveat(pub))
+	c.Assert(err, gc.IsNil)
+
+	checker := macaroon.NewChecker()
+	var verifyErr error
+	var body string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		ctx := macaroon.ContextWithRequest(req.Context(), req)
+		_, verifyErr = checker.Verify(ctx, rootKey, macaroon.Slice{m}, nil)
+		// The body is still available to the handler.
+		data, _ := io.ReadAll(req.Body)
+		body = string(data)
+	}))
+	defer srv.Close()
+
+	req, err := http.NewRequest("PUT", srv.URL+"/some/path?a=b", strings.NewReader("hello"))
+	c.Assert(err, gc.IsNil)
+	err = macaroon.SignRequest(req, priv)
+	c.Assert(err, gc.IsNil)
+	resp, err := http.DefaultClient.Do(req)
+	c.Assert(err, gc.IsNil)
+	resp.Body.Close()
+	c.Assert(verifyErr, gc.IsNil)
+	c.Assert(body, gc.Equals, "hello")
+}
+
+func (*holderSuite) TestHolderKeyCaveatErrors(c *gc.C) {
+	pub, priv := mustGenerateKey(c)
+	_, otherPriv := mustGenerateKey(c)
+	tests := []struct {
+		about     string
+		key       ed25519.PrivateKey
+		modify    func(req *http.Request)
+		now       time.Time
+		expectErr string
+	}{{
+		about: "valid signature",
+		key:   priv,
+	}, {
+		about:     "unsigned request",
+		expectErr: `request is not signed`,
+	}, {
+		about:     "wrong key",
+		key:       otherPriv,
+		expectErr: `request signature verification failed`,
+	}, {
+		about: "modified body",
+		key:   priv,
+		modify: func(req *http.Request) {
+			req.Body = io.NopCloser(strings.NewReader("goodbye"))
+		},
+		expectErr: `request signature verification failed`,
+	}, {
+		about: "modified path",
+		key:   priv,
+		modify: func(req *http.Request) {
+			req.URL.Path = "/other"
+		},
+		expectErr: `request signature verification failed`,
+	}, {
+		about: "modified method",
+		key:   priv,
+		modify: func(req *http.Request) {
+			req.Method = "DELETE"
+		},
+		expectErr: `request signature verification failed`,
+	}, {
+		about:     "signature too old",
+		key:       priv,
+		now:       time.Now().Add(10 * time.Minute),
+		expectErr: `request signature timestamp out of range`,
+	}, {
+		about:     "signature in the future",
+		key:       priv,
+		now:       time.Now().Add(-time.Minute),
+		expectErr: `request signature timestamp out of range`,
+	}, {
+		about: "bad timestamp",
+		key:   priv,
+		modify: func(req *http.Request) {
+			req.Header.Set(macaroon.HolderTimestampHeader, "yesterday")
+		},
+		expectErr: `invalid request signature timestamp "yesterday"`,
+	}}
+	for i, test := range tests {
+		c.Logf("test %d: %s", i, test.about)
+		req := httptest.NewRequest("POST", "/some/path", strings.NewReader("hello"))
+		if test.key != nil {
+			err := macaroon.SignRequest(req, test.key)
+			c.Assert(err, gc.IsNil)
+		}
+		if test.modify != nil {
+			test.modify(req)
+		}
+		checker := macaroon.NewChecker()
+		if !test.now.IsZero() {
+			checker.Clock = func() time.Time {
+				return test.now
+			}
+		}
+		ctx := macaroon.ContextWithRequest(context.Background(), req)
+		err := checker.CheckFirstPartyCaveat(ctx, macaroon.HolderKeyCaveat(pub))
+		if test.expectErr != "" {
+			c.Assert(err, gc.ErrorMatches, `caveat "holder-key .*" not satisfied: `+test.expectErr)
+		} else {
+			c.Assert(err, gc.IsNil)
+		}
+	}
+
+	req := httptest.NewRequest("GET", "/", nil)
+	ctx := macaroon.ContextWithRequest(context.Background(), req)
+	err := macaroon.NewChecker().CheckFirstPartyCaveat(ctx, "holder-key xxx")
+	c.Assert(err, gc.ErrorMatches, `caveat "holder-key xxx" not satisfied: invalid holder key "xxx"`)
+}
+
+func (*holderSuite) TestBodyTooLarge(c *gc.C) {
+	pub, priv := mustGenerateKey(c)
+	cav := macaroon.HolderKeyCaveat(pub)
+	for i, test := range []struct {
+		maxSize   int64
+		body      string
+		expectErr string
+	}{{
+		maxSize: 5,
+		body:    "hello",
+	}, {
+		maxSize:   4,
+		body:      "hello",
+		expectErr: `request body too large to check signature`,
+	}, {
+		body:      strings.Repeat("x", macaroon.DefaultMaxSignedBodySize+1),
+		expectErr: `request body too large to check signature`,
+	}, {
+		maxSize: -1,
+		body:    strings.Repeat("x", macaroon.DefaultMaxSignedBodySize+1),
+	}} {
+		c.Logf("test %d: max %d, body %d bytes", i, test.maxSize, len(test.body))
+		req := httptest.NewRequest("POST", "/", strings.NewReader(test.body))
+		err := macaroon.SignRequest(req, priv)
+		c.Assert(err, gc.IsNil)
+		checker := macaroon.NewChecker()
+		checker.MaxSignedBodySize = test.maxSize
+		ctx := macaroon.ContextWithRequest(context.Background(), req)
+		err = checker.CheckFirstPartyCaveat(ctx, cav)
+		if test.expectErr != "" {
+			c.Assert(err, gc.ErrorMatches, `caveat ".*" not satisfied: `+test.expectErr)
+		} else {
+			c.Assert(err, gc.IsNil)
+		}
+		// The body can still be read in full.
+		data, err := io.ReadAll(req.Body)
+		c.Assert(err, gc.IsNil)
+		c.Assert(string(data), gc.Equals, test.body)
+	}
+}
+
+func (*holderSuite) TestHolderKeyConcurrent(c *gc.C) {
+	pub, priv := mustGenerateKey(c)
+	rootKey := []byte("secret")
+	m := MustNew(rootKey, "some id", "")
+	s := macaroon.Slice{m}
+	for i := 0; i < 4; i++ {
+		caveatKey := []byte(fmt.Sprint("third party key ", i))
+		caveatId := fmt.Sprint("caveat ", i)
+		err := m.AddThirdPartyCaveat(caveatKey, caveatId, "remote")
+		c.Assert(err, gc.IsNil)
+		dm := MustNew(caveatKey, caveatId, "remote")
+		err = dm.AddFirstPartyCaveat(macaroon.HolderKeyCaveat(pub))
+		c.Assert(err, gc.IsNil)
+		s = append(s, dm)
+	}
+	s.Bind()
+
+	// The body is read only once, even when the holder-key
+	// caveats are checked concurrently.
+	req := httptest.NewRequest("PUT", "/", strings.NewReader("hello"))
+	err := macaroon.SignRequest(req, priv)
+	c.Assert(err, gc.IsNil)
+	ctx := macaroon.ContextWithRequest(context.Background(), req)
+	_, err = macaroon.NewChecker().Verify(ctx, rootKey, s, &macaroon.VerifyOptions{
+		Workers: 4,
+	})
+	c.Assert(err, gc.IsNil)
+	data, err := io.ReadAll(req.Body)
+	c.Assert(err, gc.IsNil)
+	c.Assert(string(data), gc.Equals, "hello")
+}
+
+func (*holderSuite) TestHolderKeyCached(c *gc.C) {
+	pub, priv := mustGenerateKey(c)
+	rootKey := []byte("secret")
+	m := MustNew(rootKey, "some id", "")
+	err := m.AddFirstPartyCaveat(macaroon.HolderKeyCaveat(pub))
+	c.Assert(err, gc.IsNil)
+	checker := macaroon.NewChecker()
+	opts := &macaroon.VerifyOptions{
+		Cache: macaroon.NewVerifyCache(10),
+		Dynamic: func(string) bool {
+			return false
+		},
+	}
+
+	req := httptest.NewRequest("GET", "/", nil)
+	err = macaroon.SignRequest(req, priv)
+	c.Assert(err, gc.IsNil)
+	ctx := macaroon.ContextWithRequest(context.Background(), req)
+	_, err = checker.Verify(ctx, rootKey, macaroon.Slice{m}, opts)
+	c.Assert(err, gc.IsNil)
+	c.Assert(opts.Cache.Len(), gc.Equals, 1)
+
+	// The cached result does not allow an unsigned request.
+	req = httptest.NewRequest("DELETE", "/", nil)
+	ctx = macaroon.ContextWithRequest(context.Background(), req)
+	_, err = checker.Verify(ctx, rootKey, macaroon.Slice{m}, opts)
+	c.Assert(err, gc.ErrorMatches, `caveat "holder-key .*" not satisfied: request is not signed`)
+}
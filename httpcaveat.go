@@ -0,0 +1,225 @@
+package macaroon
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"path"
+	"strings"
+)
+
+const (
+	// CondHTTPMethod is the condition name of
+	// caveats created by MethodsCaveat.
+	CondHTTPMethod = "http-method"
+
+	// CondHTTPPath is the condition name of
+	// caveats created by PathPrefixCaveat.
+	CondHTTPPath = "http-path"
+
+	// CondHTTPHost is the condition name of
+	// caveats created by HostsCaveat.
+	CondHTTPHost = "http-host"
+
+	// CondClientIP is the condition name of
+	// caveats created by ClientIPCaveat.
+	CondClientIP = "client-ip"
+)
+
+type requestKey struct{}
+
+// ContextWithRequest returns a context holding the given HTTP
+// request, which is used by the checkers for the HTTP request
+// caveats. Those caveats fail if there is no request in the
+// context.
+func ContextWithRequest(ctx context.Context, req *http.Request) context.Context {
+	return context.WithValue(ctx, requestKey{}, req)
+}
+
+// RequestFromContext returns the HTTP request held
+// in the context by ContextWithRequest.
+func RequestFromContext(ctx context.Context) *http.Request {
+	req, _ := ctx.Value(requestKey{}).(*http.Request)
+	return req
+}
+
+func requestFromContext(ctx context.Context) (*http.Request, error) {
+	req := RequestFromContext(ctx)
+	if req == nil {
+		return nil, fmt.Errorf("no HTTP request in context")
+	}
+	return req, nil
+}
+
+// MethodsCaveat returns a first party caveat that is satisfied
+// only by HTTP requests with one of the given methods.
+// Methods are case sensitive and are converted to upper case.
+func MethodsCaveat(methods ...string) string {
+	methods = append([]string(nil), methods...)
+	for i, method := range methods {
+		methods[i] = strings.ToUpper(method)
+	}
+	return Condition(CondHTTPMethod, strings.Join(methods, ","))
+}
+
+func checkMethod(ctx context.Context, _, arg string) error {
+	req, err := requestFromContext(ctx)
+	if err != nil {
+		return err
+	}
+	method := req.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+	for _, m := range strings.Split(arg, ",") {
+		if m == method {
+			return nil
+		}
+	}
+	return fmt.Errorf("method %q not allowed", method)
+}
+
+// PathPrefixCaveat returns a first party caveat that is satisfied
+// only by HTTP requests for the given URL path or paths below it.
+// Paths are compared after cleaning with path.Clean and matched on
+// whole path elements, so a prefix of "/a" allows "/a" and "/a/b"
+// but not "/ab" or "/a/../b".
+func PathPrefixCaveat(prefix string) string {
+	return Condition(CondHTTPPath, cleanPath(prefix))
+}
+
+func cleanPath(p string) string {
+	if !strings.HasPrefix(p, "/") {
+		p = "/" + p
+	}
+	return path.Clean(p)
+}
+
+func checkPath(ctx context.Context, _, arg string) error {
+	req, err := requestFromContext(ctx)
+	if err != nil {
+		return err
+	}
+	prefix := cleanPath(arg)
+	p := cleanPath(req.URL.Path)
+	if prefix == "/" || p == prefix || strings.HasPrefix(p, prefix+"/") {
+		return nil
+	}
+	return fmt.Errorf("path %q not allowed", p)
+}
+
+// HostsCaveat returns a first party caveat that is satisfied
+// only by HTTP requests to one of the given hosts. Host names
+// are compared case-insensitively, ignoring any port and
+// trailing dot. Empty host names are ignored; if there are
+// no others, the caveat is never satisfied.
+func HostsCaveat(hosts ...string) string {
+	var hosts1 []string
+	for _, host := range hosts {
+		if host = canonicalHost(host); host != "" {
+			hosts1 = append(hosts1, host)
+		}
+	}
+	return Condition(CondHTTPHost, strings.Join(hosts1, ","))
+}
+
+// canonicalHost returns the given host with any port, IPv6
+// brackets and trailing dot removed, in lower case.
+func canonicalHost(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.TrimPrefix(host, "[")
+	host = strings.TrimSuffix(host, "]")
+	host = strings.TrimSuffix(host, ".")
+	if addr, err := netip.ParseAddr(host); err == nil {
+		return addr.Unmap().String()
+	}
+	return strings.ToLower(host)
+}
+
+func checkHost(ctx context.Context, _, arg string) error {
+	req, err := requestFromContext(ctx)
+	if err != nil {
+		return err
+	}
+	if arg == "" {
+		return fmt.Errorf("no hosts allowed")
+	}
+	host := canonicalHost(req.Host)
+	for _, h := range strings.Split(arg, ",") {
+		// An empty entry must not match a request
+		// with no host.
+		if h := canonicalHost(h); h != "" && h == host {
+			return nil
+		}
+	}
+	return fmt.Errorf("host %q not allowed", host)
+}
+
+// ClientIPCaveat returns a first party caveat that is satisfied only
+// by HTTP requests from a client address within one of the given
+// prefixes. The client address is taken from the request's RemoteAddr
+// field; servers behind a proxy should set that field to the real
+// client address before verifying. IPv4 addresses are matched the
+// same way whether or not they are embedded in IPv6 addresses.
+func ClientIPCaveat(prefixes ...netip.Prefix) string {
+	ss := make([]string, len(prefixes))
+	for i, p := range prefixes {
+		ss[i] = canonicalPrefix(p).String()
+	}
+	return Condition(CondClientIP, strings.Join(ss, ","))
+}
+
+// canonicalPrefix returns p with IPv4-mapped IPv6 addresses
+// converted to IPv4 and with the host bits cleared.
+func canonicalPrefix(p netip.Prefix) netip.Prefix {
+	if p.Addr().Is4In6() && p.Bits() >= 96 {
+		p = netip.PrefixFrom(p.Addr().Unmap(), p.Bits()-96)
+	}
+	return p.Masked()
+}
+
+func parsePrefix(s string) (netip.Prefix, error) {
+	if !strings.Contains(s, "/") {
+		addr, err := netip.ParseAddr(s)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		addr = addr.Unmap().WithZone("")
+		return netip.PrefixFrom(addr, addr.BitLen()), nil
+	}
+	p, err := netip.ParsePrefix(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return canonicalPrefix(p), nil
+}
+
+func checkClientIP(ctx context.Context, _, arg string) error {
+	req, err := requestFromContext(ctx)
+	if err != nil {
+		return err
+	}
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		host = req.RemoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return fmt.Errorf("cannot parse client address %q", req.RemoteAddr)
+	}
+	addr = addr.Unmap().WithZone("")
+	for _, s := range strings.Split(arg, ",") {
+		p, err := parsePrefix(s)
+		if err != nil {
+			return fmt.Errorf("bad address prefix %q: %v", s, err)
+		}
+		if p.Contains(addr) {
+			return nil
+		}
+	}
+	return fmt.Errorf("client address %v not allowed", addr)
+}
@@ -0,0 +1,195 @@
+package macaroon_test
+
+import (
+	"context"
+	"net/http/httptest"
+	"net/netip"
+
+	gc "gopkg.in/check.v1"
+
+	"github.com/iron-io/macaroon"
+)
+
+type httpCaveatSuite struct{}
+
+var _ = gc.Suite(&httpCaveatSuite{})
+
+var httpCaveatTests = []struct {
+	about      string
+	caveat     string
+	method     string
+	url        string
+	host       string
+	remoteAddr string
+	expectErr  string
+}{{
+	about:  "allowed method",
+	caveat: macaroon.MethodsCaveat("get", "HEAD"),
+	method: "HEAD",
+}, {
+	about:     "disallowed method",
+	caveat:    macaroon.MethodsCaveat("GET", "HEAD"),
+	method:    "POST",
+	expectErr: `method "POST" not allowed`,
+}, {
+	about:     "method case matters",
+	caveat:    macaroon.MethodsCaveat("GET"),
+	method:    "get",
+	expectErr: `method "get" not allowed`,
+}, {
+	about:  "path equal to prefix",
+	caveat: macaroon.PathPrefixCaveat("/api/v1/"),
+	url:    "/api/v1",
+}, {
+	about:  "path below prefix",
+	caveat: macaroon.PathPrefixCaveat("/api/v1"),
+	url:    "/api/v1/users/bob?x=y",
+}, {
+	about:     "path sharing prefix characters",
+	caveat:    macaroon.PathPrefixCaveat("/api/v1"),
+	url:       "/api/v10/users",
+	expectErr: `path "/api/v10/users" not allowed`,
+}, {
+	about:     "path escaping prefix with dot dot",
+	caveat:    macaroon.PathPrefixCaveat("/api/v1"),
+	url:       "/api/v1/../../admin",
+	expectErr: `path "/admin" not allowed`,
+}, {
+	about:     "path escaping prefix with encoded dot dot",
+	caveat:    macaroon.PathPrefixCaveat("/api/v1"),
+	url:       "/api/v1/%2e%2e/v2",
+	expectErr: `path "/api/v2" not allowed`,
+}, {
+	about:  "path with repeated slashes",
+	caveat: macaroon.PathPrefixCaveat("/api/v1"),
+	url:    "//api//v1//x",
+}, {
+	about:  "root prefix",
+	caveat: macaroon.PathPrefixCaveat("/"),
+	url:    "/anything",
+}, {
+	about:  "host",
+	caveat: macaroon.HostsCaveat("Example.COM.", "other.com"),
+	host:   "example.com:8080",
+}, {
+	about:  "host with trailing dot",
+	caveat: macaroon.HostsCaveat("example.com"),
+	host:   "EXAMPLE.com.",
+}, {
+	about:     "disallowed host",
+	caveat:    macaroon.HostsCaveat("example.com"),
+	host:      "example.com.evil.com",
+	expectErr: `host "example.com.evil.com" not allowed`,
+}, {
+	about:     "no hosts",
+	caveat:    macaroon.HostsCaveat(),
+	host:      ":80",
+	expectErr: `no hosts allowed`,
+}, {
+	about:     "empty host name",
+	caveat:    macaroon.HostsCaveat("", "[]"),
+	host:      ":80",
+	expectErr: `no hosts allowed`,
+}, {
+	about:     "empty host entry",
+	caveat:    "http-host ,example.com",
+	host:      ":80",
+	expectErr: `host "" not allowed`,
+}, {
+	about:  "IPv6 host",
+	caveat: macaroon.HostsCaveat("[::1]:443"),
+	host:   "[0:0::1]",
+}, {
+	about:      "client IP in range",
+	caveat:     macaroon.ClientIPCaveat(netip.MustParsePrefix("10.0.0.0/8")),
+	remoteAddr: "10.1.2.3:1234",
+}, {
+	about:      "client IP out of range",
+	caveat:     macaroon.ClientIPCaveat(netip.MustParsePrefix("10.0.0.0/8")),
+	remoteAddr: "11.1.2.3:1234",
+	expectErr:  `client address 11.1.2.3 not allowed`,
+}, {
+	about:      "IPv4-mapped client address",
+	caveat:     macaroon.ClientIPCaveat(netip.MustParsePrefix("10.0.0.0/8")),
+	remoteAddr: "[::ffff:10.1.2.3]:1234",
+}, {
+	about:      "IPv4-mapped prefix",
+	caveat:     macaroon.ClientIPCaveat(netip.MustParsePrefix("::ffff:10.0.0.0/104")),
+	remoteAddr: "10.1.2.3:1234",
+}, {
+	about:      "IPv6 client address",
+	caveat:     macaroon.ClientIPCaveat(netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("2001:db8::/32")),
+	remoteAddr: "[2001:db8::1%eth0]:1234",
+}, {
+	about:      "single address",
+	caveat:     "client-ip 192.168.1.1",
+	remoteAddr: "192.168.1.1:1234",
+}, {
+	about:      "unmasked prefix",
+	caveat:     macaroon.ClientIPCaveat(netip.MustParsePrefix("192.168.1.77/24")),
+	remoteAddr: "192.168.1.1:1234",
+}, {
+	about:      "bad client address",
+	caveat:     macaroon.ClientIPCaveat(netip.MustParsePrefix("10.0.0.0/8")),
+	remoteAddr: "somewhere",
+	expectErr:  `cannot parse client address "somewhere"`,
+}, {
+	about:      "bad prefix",
+	caveat:     "client-ip 10.0.0.0/99",
+	remoteAddr: "10.1.2.3:1234",
+	expectErr:  `bad address prefix "10.0.0.0/99": .*`,
+}}
+
+func (*httpCaveatSuite) TestHTTPCaveats(c *gc.C) {
+	checker := macaroon.NewChecker()
+	for i, test := range httpCaveatTests {
+		c.Logf("test %d: %s", i, test.about)
+		url := test.url
+		if url == "" {
+			url = "/"
+		}
+		req := httptest.NewRequest(test.method, url, nil)
+		if test.host != "" {
+			req.Host = test.host
+		}
+		if test.remoteAddr != "" {
+			req.RemoteAddr = test.remoteAddr
+		}
+		ctx := macaroon.ContextWithRequest(context.Background(), req)
+		err := checker.CheckFirstPartyCaveat(ctx, test.caveat)
+		if test.expectErr != "" {
+			c.Assert(err, gc.ErrorMatches, `caveat ".*" not satisfied: `+test.expectErr)
+		} else {
+			c.Assert(err, gc.IsNil)
+		}
+	}
+}
+
+func (*httpCaveatSuite) TestNoRequest(c *gc.C) {
+	checker := macaroon.NewChecker()
+	err := checker.CheckFirstPartyCaveat(context.Background(), macaroon.MethodsCaveat("GET"))
+	c.Assert(err, gc.ErrorMatches, `caveat "http-method GET" not satisfied: no HTTP request in context`)
+}
+
+func (*httpCaveatSuite) TestVerifyWithRequest(c *gc.C) {
+	rootKey := []byte("secret")
+	m := MustNew(rootKey, "some id", "")
+	for _, cav := range []string{
+		macaroon.MethodsCaveat("GET"),
+		macaroon.PathPrefixCaveat("/files"),
+		macaroon.HostsCaveat("example.com"),
+	} {
+		err := m.AddFirstPartyCaveat(cav)
+		c.Assert(err, gc.IsNil)
+	}
+	checker := macaroon.NewChecker()
+	req := httptest.NewRequest("GET", "http://example.com/files/a.txt", nil)
+	ctx := macaroon.ContextWithRequest(context.Background(), req)
+	_, err := checker.Verify(ctx, rootKey, macaroon.Slice{m}, nil)
+	c.Assert(err, gc.IsNil)
+
+	req = httptest.NewRequest("DELETE", "http://example.com/files/a.txt", nil)
+	ctx = macaroon.ContextWithRequest(context.Background(), req)
+	_, err = checker.Verify(ctx, rootKey, macaroon.Slice{m}, nil)
+	c.Assert(err, gc.ErrorMatches, `caveat "http-method GET" not satisfied: method "DELETE" not allowed`)
+}
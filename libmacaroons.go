@@ -0,0 +1,391 @@
+package macaroon
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// The libmacaroons version 1 format is a sequence of packets,
+// each of which holds:
+//
+// - four ascii hex digits holding the entire packet size (including
+// the digits themselves and the trailing newline).
+//
+// - the field name, followed by an ascii space.
+//
+// - the raw data, followed by a newline.
+//
+// The fields appear in the same order as in our own
+// binary format. Version 1 macaroons are usually
+// transmitted base64 encoded.
+//
+// The libmacaroons version 2 format starts with a version
+// byte (2) followed by a sequence of sections, each holding
+// fields in ascending order of field type and terminated by an
+// end-of-section field. Each field holds its type and
+// the length of its data as unsigned varints, followed by the data.
+//
+// The first section holds the optional location and the identifier
+// of the macaroon. A section for each caveat follows, holding
+// its optional location, identifier and optional verification
+// id. The caveats are terminated by an empty section, and the
+// signature follows as a single field.
+
+const v1MaxPacketLen = 0xffff
+
+// Field types in the version 2 format.
+const (
+	v2FieldEOS            = 0
+	v2FieldLocation       = 1
+	v2FieldIdentifier     = 2
+	v2FieldVerificationId = 4
+	v2FieldSignature      = 6
+)
+
+// MarshalV1 returns the macaroon encoded in the libmacaroons
+// version 1 binary format.
+func (m *Macaroon) MarshalV1() ([]byte, error) {
+	data, err := appendV1Packet(nil, fieldLocation, m.dataBytes(m.location))
+	if err != nil {
+		return nil, err
+	}
+	if data, err = appendV1Packet(data, fieldIdentifier, m.dataBytes(m.id)); err != nil {
+		return nil, err
+	}
+	for _, cav := range m.caveats {
+		if data, err = appendV1Packet(data, fieldCaveatId, m.dataBytes(cav.caveatId)); err != nil {
+			return nil, err
+		}
+		if cav.verificationId.len() > 0 {
+			if data, err = appendV1Packet(data, fieldVerificationId, m.dataBytes(cav.verificationId)); err != nil {
+				return nil, err
+			}
+		}
+		if cav.location.len() > 0 {
+			if data, err = appendV1Packet(data, fieldCaveatLocation, m.dataBytes(cav.location)); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return appendV1Packet(data, fieldSignature, m.sig)
+}
+
+func appendV1Packet(buf []byte, f field, data []byte) ([]byte, error) {
+	name := f.String()
+	plen := 4 + len(name) + 1 + len(data) + 1
+	if plen > v1MaxPacketLen {
+		return nil, fmt.Errorf("field %s too big for version 1 format", name)
+	}
+	buf = append(buf,
+		hexDigits[plen>>12],
+		hexDigits[(plen>>8)&0xf],
+		hexDigits[(plen>>4)&0xf],
+		hexDigits[plen&0xf],
+	)
+	buf = append(buf, name...)
+	buf = append(buf, ' ')
+	buf = append(buf, data...)
+	buf = append(buf, '\n')
+	return buf, nil
+}
+
+// UnmarshalV1 unmarshals a macaroon encoded in the libmacaroons
+// version 1 binary format. The data is checked against DefaultLimits.
+func (m *Macaroon) UnmarshalV1(data []byte) error {
+	return DefaultLimits.DecodeV1(m, data)
+}
+
+// DecodeV1 is like Macaroon.UnmarshalV1 except
+// that it applies the receiving limits instead of DefaultLimits.
+func (l Limits) DecodeV1(m *Macaroon, data []byte) error {
+	if err := l.checkSize(len(data)); err != nil {
+		return err
+	}
+	loc, data, err := expectV1Packet(data, fieldLocation)
+	if err != nil {
+		return err
+	}
+	id, data, err := expectV1Packet(data, fieldIdentifier)
+	if err != nil {
+		return err
+	}
+	if err := l.checkIdLen(len(id)); err != nil {
+		return err
+	}
+	var caveats []caveatFields
+	for {
+		f, fdata, rest, err := parseV1Packet(data)
+		if err != nil {
+			return err
+		}
+		data = rest
+		switch f {
+		case fieldSignature:
+			if len(data) > 0 {
+				return fmt.Errorf("unexpected data after signature")
+			}
+			return m.initFromFields(string(id), string(loc), caveats, fdata)
+		case fieldCaveatId:
+			if err := l.checkCaveats(len(caveats) + 1); err != nil {
+				return err
+			}
+			if err := l.checkIdLen(len(fdata)); err != nil {
+				return err
+			}
+			caveats = append(caveats, caveatFields{
+				caveatId: fdata,
+			})
+		case fieldVerificationId, fieldCaveatLocation:
+			if len(caveats) == 0 {
+				return fmt.Errorf("field %v found before caveat id", f)
+			}
+			cav := &caveats[len(caveats)-1]
+			p := &cav.verificationId
+			if f == fieldCaveatLocation {
+				p = &cav.location
+			}
+			if *p != nil {
+				return fmt.Errorf("repeated field %v in caveat", f)
+			}
+			*p = fdata
+		default:
+			return fmt.Errorf("unexpected field %v", f)
+		}
+	}
+}
+
+// caveatFields holds the fields of a caveat decoded
+// from one of the libmacaroons formats.
+type caveatFields struct {
+	caveatId       []byte
+	verificationId []byte
+	location       []byte
+}
+
+// initFromFields initializes m from its decoded fields.
+func (m *Macaroon) initFromFields(id, loc string, caveats []caveatFields, sig []byte) error {
+	*m = Macaroon{}
+	if err := m.init(id, loc); err != nil {
+		return err
+	}
+	for _, cav := range caveats {
+		if _, err := m.appendCaveat(string(cav.caveatId), cav.verificationId, string(cav.location)); err != nil {
+			return err
+		}
+	}
+	m.sig = append([]byte(nil), sig...)
+	return nil
+}
+
+func expectV1Packet(data []byte, kind field) (fdata, rest []byte, err error) {
+	f, fdata, rest, err := parseV1Packet(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	if f != kind {
+		return nil, nil, fmt.Errorf("unexpected field %v; expected %v", f, kind)
+	}
+	return fdata, rest, nil
+}
+
+// parseV1Packet parses the version 1 packet at the start
+// of data, returning its field and payload and the data
+// following it.
+func parseV1Packet(data []byte) (f field, fdata, rest []byte, err error) {
+	if len(data) < 4 {
+		return 0, nil, nil, fmt.Errorf("packet too short")
+	}
+	plen := 0
+	for _, c := range data[0:4] {
+		d := bytes.IndexByte(hexDigits, c)
+		if d < 0 {
+			return 0, nil, nil, fmt.Errorf("cannot parse packet size")
+		}
+		plen = plen<<4 | d
+	}
+	if plen > len(data) {
+		return 0, nil, nil, fmt.Errorf("packet size too big")
+	}
+	if plen < 4+2 || data[plen-1] != '\n' {
+		return 0, nil, nil, fmt.Errorf("packet not terminated by newline")
+	}
+	payload := data[4 : plen-1]
+	i := bytes.IndexByte(payload, ' ')
+	if i <= 0 {
+		return 0, nil, nil, fmt.Errorf("cannot parse field name")
+	}
+	name := string(payload[0:i])
+	for f := fieldLocation; int(f) < len(fieldStrings); f++ {
+		if fieldStrings[f] == name {
+			return f, payload[i+1:], data[plen:], nil
+		}
+	}
+	return 0, nil, nil, fmt.Errorf("unexpected field %q", name)
+}
+
+// MarshalV2 returns the macaroon encoded in the libmacaroons
+// version 2 binary format.
+func (m *Macaroon) MarshalV2() ([]byte, error) {
+	data := []byte{2}
+	if len(m.dataBytes(m.location)) > 0 {
+		data = appendV2Field(data, v2FieldLocation, m.dataBytes(m.location))
+	}
+	data = appendV2Field(data, v2FieldIdentifier, m.dataBytes(m.id))
+	data = append(data, v2FieldEOS)
+	for _, cav := range m.caveats {
+		if cav.location.len() > 0 {
+			data = appendV2Field(data, v2FieldLocation, m.dataBytes(cav.location))
+		}
+		data = appendV2Field(data, v2FieldIdentifier, m.dataBytes(cav.caveatId))
+		if cav.verificationId.len() > 0 {
+			data = appendV2Field(data, v2FieldVerificationId, m.dataBytes(cav.verificationId))
+		}
+		data = append(data, v2FieldEOS)
+	}
+	data = append(data, v2FieldEOS)
+	return appendV2Field(data, v2FieldSignature, m.sig), nil
+}
+
+func appendV2Field(buf []byte, fieldType int, data []byte) []byte {
+	buf = binary.AppendUvarint(buf, uint64(fieldType))
+	buf = binary.AppendUvarint(buf, uint64(len(data)))
+	return append(buf, data...)
+}
+
+// UnmarshalV2 unmarshals a macaroon encoded in the libmacaroons
+// version 2 binary format. The data is checked against DefaultLimits.
+func (m *Macaroon) UnmarshalV2(data []byte) error {
+	return DefaultLimits.DecodeV2(m, data)
+}
+
+// DecodeV2 is like Macaroon.UnmarshalV2 except
+// that it applies the receiving limits instead of DefaultLimits.
+func (l Limits) DecodeV2(m *Macaroon, data []byte) error {
+	if err := l.checkSize(len(data)); err != nil {
+		return err
+	}
+	if len(data) == 0 || data[0] != 2 {
+		return fmt.Errorf("unsupported macaroon version")
+	}
+	section, data, err := parseV2Section(data[1:])
+	if err != nil {
+		return err
+	}
+	loc, id, _, err := v2SectionFields(section, false)
+	if err != nil {
+		return err
+	}
+	if err := l.checkIdLen(len(id)); err != nil {
+		return err
+	}
+	var caveats []caveatFields
+	for {
+		section, data, err = parseV2Section(data)
+		if err != nil {
+			return err
+		}
+		if len(section) == 0 {
+			break
+		}
+		if err := l.checkCaveats(len(caveats) + 1); err != nil {
+			return err
+		}
+		cloc, cid, vid, err := v2SectionFields(section, true)
+		if err != nil {
+			return err
+		}
+		if err := l.checkIdLen(len(cid)); err != nil {
+			return err
+		}
+		caveats = append(caveats, caveatFields{
+			caveatId:       cid,
+			verificationId: vid,
+			location:       cloc,
+		})
+	}
+	f, data, err := parseV2Field(data)
+	if err != nil {
+		return err
+	}
+	if f.fieldType != v2FieldSignature {
+		return fmt.Errorf("unexpected field type %d; expected signature", f.fieldType)
+	}
+	if len(data) > 0 {
+		return fmt.Errorf("unexpected data after signature")
+	}
+	return m.initFromFields(string(id), string(loc), caveats, f.data)
+}
+
+// v2Field holds a field in the version 2 format.
+type v2Field struct {
+	fieldType int
+	data      []byte
+}
+
+// v2SectionFields returns the location, identifier and
+// verification id fields from the given section, which must
+// hold fields in ascending order of type. The verification id
+// is only permitted if allowVid is true.
+func v2SectionFields(section []v2Field, allowVid bool) (loc, id, vid []byte, err error) {
+	for _, f := range section {
+		switch {
+		case f.fieldType == v2FieldLocation:
+			loc = f.data
+		case f.fieldType == v2FieldIdentifier:
+			id = f.data
+		case f.fieldType == v2FieldVerificationId && allowVid:
+			vid = f.data
+		default:
+			return nil, nil, nil, fmt.Errorf("unexpected field type %d", f.fieldType)
+		}
+	}
+	if id == nil {
+		return nil, nil, nil, fmt.Errorf("identifier not found in section")
+	}
+	return loc, id, vid, nil
+}
+
+// parseV2Section parses the fields up to and including the
+// next end-of-section marker, returning the fields and the
+// data following the marker.
+func parseV2Section(data []byte) ([]v2Field, []byte, error) {
+	var section []v2Field
+	for {
+		if len(data) == 0 {
+			return nil, nil, fmt.Errorf("section not terminated")
+		}
+		if data[0] == v2FieldEOS {
+			return section, data[1:], nil
+		}
+		f, rest, err := parseV2Field(data)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(section) > 0 && f.fieldType <= section[len(section)-1].fieldType {
+			return nil, nil, fmt.Errorf("fields out of order")
+		}
+		section = append(section, f)
+		data = rest
+	}
+}
+
+func parseV2Field(data []byte) (v2Field, []byte, error) {
+	fieldType, n := binary.Uvarint(data)
+	if n <= 0 || fieldType > v2FieldSignature {
+		return v2Field{}, nil, fmt.Errorf("bad field type")
+	}
+	data = data[n:]
+	flen, n := binary.Uvarint(data)
+	if n <= 0 {
+		return v2Field{}, nil, fmt.Errorf("bad field length")
+	}
+	data = data[n:]
+	if flen > uint64(len(data)) {
+		return v2Field{}, nil, fmt.Errorf("field data extends past end of buffer")
+	}
+	return v2Field{
+		fieldType: int(fieldType),
+		data:      data[0:flen],
+	}, data[flen:], nil
+}
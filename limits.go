@@ -0,0 +1,121 @@
+package macaroon
+
+import (
+	"fmt"
+)
+
+// Limits holds bounds on the macaroon data that will be accepted
+// when decoding and verifying macaroons. They exist so that a
+// single attacker-supplied value cannot cause excessive
+// allocation or recursion. A zero field means that no limit
+// is applied.
+type Limits struct {
+	// MaxSize holds the maximum size in bytes of
+	// the encoded data. Compressed data is also
+	// checked against it after decompression.
+	MaxSize int
+
+	// MaxCaveats holds the maximum number of caveats
+	// in a single macaroon.
+	MaxCaveats int
+
+	// MaxDischarges holds the maximum number of
+	// discharge macaroons in a Slice.
+	MaxDischarges int
+
+	// MaxIdLen holds the maximum length of a macaroon
+	// identifier or caveat identifier.
+	MaxIdLen int
+
+	// MaxDepth holds the maximum nesting depth of third
+	// party caveats that will be followed by Verify.
+	// The primary macaroon is at depth zero.
+	MaxDepth int
+}
+
+// DefaultLimits holds the limits used by UnmarshalBinary,
+// UnmarshalJSON and Verify.
+var DefaultLimits = Limits{
+	MaxSize:       1024 * 1024,
+	MaxCaveats:    1024,
+	MaxDischarges: 256,
+	MaxIdLen:      8192,
+	MaxDepth:      32,
+}
+
+// LimitError is the error returned when some data
+// exceeds one of the bounds in Limits.
+type LimitError struct {
+	// Limit holds the name of the Limits field
+	// that was exceeded, for example "MaxCaveats".
+	Limit string
+
+	// Max holds the value of that field.
+	Max int
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("macaroon limit exceeded: %s is %d", e.Limit, e.Max)
+}
+
+// checkSize returns an error if size exceeds the MaxSize limit.
+func (l *Limits) checkSize(size int) error {
+	if l.MaxSize > 0 && size > l.MaxSize {
+		return &LimitError{"MaxSize", l.MaxSize}
+	}
+	return nil
+}
+
+// checkCaveats returns an error if n exceeds the MaxCaveats limit.
+func (l *Limits) checkCaveats(n int) error {
+	if l.MaxCaveats > 0 && n > l.MaxCaveats {
+		return &LimitError{"MaxCaveats", l.MaxCaveats}
+	}
+	return nil
+}
+
+// checkDischarges returns an error if n exceeds the MaxDischarges limit.
+func (l *Limits) checkDischarges(n int) error {
+	if l.MaxDischarges > 0 && n > l.MaxDischarges {
+		return &LimitError{"MaxDischarges", l.MaxDischarges}
+	}
+	return nil
+}
+
+// checkIdLen returns an error if n exceeds the MaxIdLen limit.
+func (l *Limits) checkIdLen(n int) error {
+	if l.MaxIdLen > 0 && n > l.MaxIdLen {
+		return &LimitError{"MaxIdLen", l.MaxIdLen}
+	}
+	return nil
+}
+
+// checkDepth returns an error if depth exceeds the MaxDepth limit.
+func (l *Limits) checkDepth(depth int) error {
+	if l.MaxDepth > 0 && depth > l.MaxDepth {
+		return &LimitError{"MaxDepth", l.MaxDepth}
+	}
+	return nil
+}
+
+// DecodeBinary is like Macaroon.UnmarshalBinary except
+// that it applies the receiving limits instead of DefaultLimits.
+func (l Limits) DecodeBinary(m *Macaroon, data []byte) error {
+	data, err := l.decodeData(data)
+	if err != nil {
+		return err
+	}
+	return m.unmarshalBinaryNoCopy(data, &l)
+}
+
+// DecodeJSON is like Macaroon.UnmarshalJSON except
+// that it applies the receiving limits instead of DefaultLimits.
+func (l Limits) DecodeJSON(m *Macaroon, data []byte) error {
+	return m.unmarshalJSON(data, &l)
+}
+
+// DecodeSlice is like Slice.UnmarshalBinary except
+// that it applies the receiving limits instead of DefaultLimits.
+func (l Limits) DecodeSlice(s *Slice, data []byte) error {
+	return s.unmarshalBinary(data, &l)
+}
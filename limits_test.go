@@ -0,0 +1,153 @@
+package macaroon_test
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	gc "gopkg.in/check.v1"
+
+	"github.com/iron-io/macaroon"
+)
+
+type limitsSuite struct{}
+
+var _ = gc.Suite(&limitsSuite{})
+
+func macaroonWithCaveats(n int) *macaroon.Macaroon {
+	m := MustNew([]byte("secret"), "some id", "a location")
+	for i := 0; i < n; i++ {
+		if err := m.AddFirstPartyCaveat(fmt.Sprintf("caveat %d", i)); err != nil {
+			panic(err)
+		}
+	}
+	return m
+}
+
+func assertLimitError(c *gc.C, err error, limit string) {
+	var lerr *macaroon.LimitError
+	c.Assert(errors.As(err, &lerr), gc.Equals, true, gc.Commentf("error %v", err))
+	c.Assert(lerr.Limit, gc.Equals, limit)
+}
+
+func (*limitsSuite) TestDecodeBinaryMaxCaveats(c *gc.C) {
+	data, err := macaroonWithCaveats(5).MarshalBinary()
+	c.Assert(err, gc.IsNil)
+
+	var m macaroon.Macaroon
+	err = macaroon.Limits{MaxCaveats: 5}.DecodeBinary(&m, data)
+	c.Assert(err, gc.IsNil)
+	c.Assert(m.Caveats(), gc.HasLen, 5)
+
+	err = macaroon.Limits{MaxCaveats: 4}.DecodeBinary(&m, data)
+	c.Assert(err, gc.ErrorMatches, `macaroon limit exceeded: MaxCaveats is 4`)
+	assertLimitError(c, err, "MaxCaveats")
+}
+
+func (*limitsSuite) TestDecodeBinaryMaxIdLen(c *gc.C) {
+	m0 := MustNew([]byte("secret"), strings.Repeat("x", 100), "a location")
+	data, err := m0.MarshalBinary()
+	c.Assert(err, gc.IsNil)
+	var m macaroon.Macaroon
+	err = macaroon.Limits{MaxIdLen: 99}.DecodeBinary(&m, data)
+	assertLimitError(c, err, "MaxIdLen")
+
+	m0 = MustNew([]byte("secret"), "some id", "a location")
+	err = m0.AddFirstPartyCaveat(strings.Repeat("x", 100))
+	c.Assert(err, gc.IsNil)
+	data, err = m0.MarshalBinary()
+	c.Assert(err, gc.IsNil)
+	err = macaroon.Limits{MaxIdLen: 99}.DecodeBinary(&m, data)
+	assertLimitError(c, err, "MaxIdLen")
+	err = macaroon.Limits{MaxIdLen: 100}.DecodeBinary(&m, data)
+	c.Assert(err, gc.IsNil)
+}
+
+func (*limitsSuite) TestDecodeBinaryMaxSize(c *gc.C) {
+	data, err := macaroonWithCaveats(5).MarshalBinary()
+	c.Assert(err, gc.IsNil)
+	var m macaroon.Macaroon
+	err = macaroon.Limits{MaxSize: len(data) - 1}.DecodeBinary(&m, data)
+	assertLimitError(c, err, "MaxSize")
+	err = macaroon.Limits{MaxSize: len(data)}.DecodeBinary(&m, data)
+	c.Assert(err, gc.IsNil)
+}
+
+func (*limitsSuite) TestDecodeJSON(c *gc.C) {
+	data, err := json.Marshal(macaroonWithCaveats(5))
+	c.Assert(err, gc.IsNil)
+
+	var m macaroon.Macaroon
+	err = macaroon.Limits{MaxCaveats: 4}.DecodeJSON(&m, data)
+	assertLimitError(c, err, "MaxCaveats")
+	err = macaroon.Limits{MaxSize: 10}.DecodeJSON(&m, data)
+	assertLimitError(c, err, "MaxSize")
+	err = macaroon.Limits{MaxIdLen: 6}.DecodeJSON(&m, data)
+	assertLimitError(c, err, "MaxIdLen")
+	err = macaroon.Limits{MaxCaveats: 5}.DecodeJSON(&m, data)
+	c.Assert(err, gc.IsNil)
+	c.Assert(m.Caveats(), gc.HasLen, 5)
+}
+
+func (*limitsSuite) TestDefaultLimitsApplied(c *gc.C) {
+	data, err := macaroonWithCaveats(macaroon.DefaultLimits.MaxCaveats + 1).MarshalBinary()
+	c.Assert(err, gc.IsNil)
+	var m macaroon.Macaroon
+	err = m.UnmarshalBinary(data)
+	assertLimitError(c, err, "MaxCaveats")
+
+	data, err = json.Marshal(macaroonWithCaveats(macaroon.DefaultLimits.MaxCaveats + 1))
+	c.Assert(err, gc.IsNil)
+	err = json.Unmarshal(data, &m)
+	assertLimitError(c, err, "MaxCaveats")
+}
+
+func (*limitsSuite) TestDecodeSliceMaxDischarges(c *gc.C) {
+	s := macaroon.Slice{
+		MustNew([]byte("secret"), "primary", ""),
+		MustNew([]byte("secret"), "discharge 1", ""),
+		MustNew([]byte("secret"), "discharge 2", ""),
+	}
+	data, err := s.MarshalBinary()
+	c.Assert(err, gc.IsNil)
+
+	var s1 macaroon.Slice
+	err = macaroon.Limits{MaxDischarges: 1}.DecodeSlice(&s1, data)
+	c.Assert(err, gc.ErrorMatches, `macaroon limit exceeded: MaxDischarges is 1`)
+	assertLimitError(c, err, "MaxDischarges")
+
+	err = macaroon.Limits{MaxDischarges: 2}.DecodeSlice(&s1, data)
+	c.Assert(err, gc.IsNil)
+	c.Assert(s1, gc.HasLen, 3)
+
+	err = macaroon.Limits{MaxCaveats: 1}.DecodeSlice(&s1, mustMarshalBinary(macaroonWithCaveats(2)))
+	c.Assert(err, gc.ErrorMatches, `cannot unmarshal macaroon: macaroon limit exceeded: MaxCaveats is 1`)
+	assertLimitError(c, err, "MaxCaveats")
+}
+
+func (*limitsSuite) TestVerifyMaxDepth(c *gc.C) {
+	rootKey, primary, discharges := makeMacaroons(recursiveThirdPartyCaveatMacaroons)
+	check := func(string) error {
+		return nil
+	}
+	// The deepest discharge (ben-is-great) is at depth 3.
+	err := primary.VerifyWithOptions(rootKey, check, discharges, &macaroon.VerifyOptions{
+		Limits: &macaroon.Limits{MaxDepth: 3},
+	})
+	c.Assert(err, gc.IsNil)
+
+	err = primary.VerifyWithOptions(rootKey, check, discharges, &macaroon.VerifyOptions{
+		Limits: &macaroon.Limits{MaxDepth: 2},
+	})
+	c.Assert(err, gc.ErrorMatches, `macaroon limit exceeded: MaxDepth is 2`)
+	assertLimitError(c, err, "MaxDepth")
+}
+
+func mustMarshalBinary(m *macaroon.Macaroon) []byte {
+	data, err := m.MarshalBinary()
+	if err != nil {
+		panic(err)
+	}
+	return data
+}
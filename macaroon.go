@@ -5,22 +5,31 @@
 //
 // See the macaroon bakery packages at http://godoc.org/gopkg.in/macaroon-bakery.v0
 // for higher level services and operations that use macaroons.
+//
+// Signatures are computed as by libmacaroons, using HMAC-SHA256
+// with a root key derived from the one supplied, so macaroons
+// can be exchanged with other implementations. Earlier versions
+// of this package used HMAC-SHA1 with the root key as given;
+// macaroons minted by those versions do not verify with this one,
+// and must be reissued when upgrading.
 package macaroon
 
 import (
 	"bytes"
 	"crypto/hmac"
 	"crypto/rand"
-	"crypto/sha256"
 	"fmt"
 	"io"
+	"sync"
+	"sync/atomic"
 )
 
 // Macaroon holds a macaroon.
 // See Fig. 7 of http://theory.stanford.edu/~ataly/Papers/macaroons.pdf
 // for a description of the data contained within.
 // Macaroons are mutable objects - use Clone as appropriate
-// to avoid unwanted mutation.
+// to avoid unwanted mutation, or Freeze to obtain a value
+// that can be shared safely between goroutines.
 type Macaroon struct {
 	// data holds the binary-marshalled form
 	// of the macaroon data.
@@ -57,7 +66,7 @@ func New(rootKey []byte, id, loc string) (*Macaroon, error) {
 	if err := m.init(id, loc); err != nil {
 		return nil, err
 	}
-	m.sig = keyedHash(rootKey, m.dataBytes(m.id))
+	m.sig = keyedHash(makeKey(rootKey), m.dataBytes(m.id))
 	return &m, nil
 }
 
@@ -85,6 +94,34 @@ func (m *Macaroon) Clone() *Macaroon {
 	return &m1
 }
 
+// deepCopy returns a copy of m that shares no
+// memory with it.
+func (m *Macaroon) deepCopy() *Macaroon {
+	m1 := *m
+	m1.data = append([]byte(nil), m.data...)
+	m1.caveats = append([]caveat(nil), m.caveats...)
+	m1.sig = append([]byte(nil), m.sig...)
+	return &m1
+}
+
+// Restrict returns a new macaroon that is a copy of m with first
+// party caveats added for each of the given conditions. It can be
+// used to delegate a restricted copy of a macaroon; m itself is
+// not changed, and the new macaroon shares no memory with it.
+//
+// A discharge macaroon may be restricted in the same way, but this
+// must be done before it is bound with Bind; restricting a bound
+// discharge makes it invalid.
+func (m *Macaroon) Restrict(conditions ...string) (*Macaroon, error) {
+	m1 := m.deepCopy()
+	for _, cond := range conditions {
+		if err := m1.AddFirstPartyCaveat(cond); err != nil {
+			return nil, err
+		}
+	}
+	return m1, nil
+}
+
 // Location returns the macaroon's location hint. This is
 // not verified as part of the macaroon.
 func (m *Macaroon) Location() string {
@@ -119,11 +156,11 @@ func (m *Macaroon) Caveats() []Caveat {
 func (m *Macaroon) appendCaveat(caveatId string, verificationId []byte, loc string) (*caveat, error) {
 	var cav caveat
 	var ok bool
-	if caveatId != "" {
-		cav.caveatId, ok = m.appendPacket(fieldCaveatId, []byte(caveatId))
-		if !ok {
-			return nil, fmt.Errorf("caveat identifier too big")
-		}
+	// The caveat id packet is always present, even when empty,
+	// because it marks the start of the caveat in the binary encoding.
+	cav.caveatId, ok = m.appendPacket(fieldCaveatId, []byte(caveatId))
+	if !ok {
+		return nil, fmt.Errorf("caveat identifier too big")
 	}
 	if len(verificationId) > 0 {
 		cav.verificationId, ok = m.appendPacket(fieldVerificationId, verificationId)
@@ -146,13 +183,29 @@ func (m *Macaroon) addCaveat(caveatId string, verificationId []byte, loc string)
 	if err != nil {
 		return err
 	}
-	sig := keyedHasher(m.sig)
-	sig.Write(m.dataBytes(cav.verificationId))
-	sig.Write(m.dataBytes(cav.caveatId))
-	m.sig = sig.Sum(m.sig[:0])
+	m.sig = m.caveatSig(m.sig, cav)
 	return nil
 }
 
+// caveatSig returns the signature that results from adding
+// the given caveat to a macaroon with the signature sig.
+func (m *Macaroon) caveatSig(sig []byte, cav *caveat) []byte {
+	if cav.isThirdParty() {
+		return keyedHash2(sig, m.dataBytes(cav.verificationId), m.dataBytes(cav.caveatId))
+	}
+	return keyedHash(sig, m.dataBytes(cav.caveatId))
+}
+
+// scratchCaveatSig is like caveatSig except that it
+// updates the signature held in sc.sig.
+func (m *Macaroon) scratchCaveatSig(sc *scratch, cav *caveat) {
+	if cav.isThirdParty() {
+		sc.keyedHash2(&sc.sig, sc.sig[:], m.dataBytes(cav.verificationId), m.dataBytes(cav.caveatId))
+	} else {
+		sc.keyedHash(&sc.sig, sc.sig[:], m.dataBytes(cav.caveatId))
+	}
+}
+
 // Bind prepares the macaroon for being used to discharge the
 // macaroon with the given signature sig. This must be
 // used before it is used in the discharges argument to Verify.
@@ -173,27 +226,31 @@ func (m *Macaroon) AddFirstPartyCaveat(caveatId string) error {
 // or by holding a reference to it stored in the third party's
 // storage.
 func (m *Macaroon) AddThirdPartyCaveat(rootKey []byte, caveatId string, loc string) error {
-	return m.addThirdPartyCaveatWithRand(rootKey, caveatId, loc, rand.Reader)
+	return m.AddThirdPartyCaveatWithRand(rootKey, caveatId, loc, rand.Reader)
 }
 
-func (m *Macaroon) addThirdPartyCaveatWithRand(rootKey []byte, caveatId string, loc string, r io.Reader) error {
-	verificationId, err := encrypt(m.sig, rootKey, r)
+// AddThirdPartyCaveatWithRand is like AddThirdPartyCaveat except
+// that it uses r as the source of randomness when encrypting
+// the root key. This can be used to create reproducible caveats
+// in tests; in production code r should be rand.Reader.
+func (m *Macaroon) AddThirdPartyCaveatWithRand(rootKey []byte, caveatId string, loc string, r io.Reader) error {
+	verificationId, err := encrypt(m.sig, makeKey(rootKey), r)
 	if err != nil {
 		return err
 	}
 	return m.addCaveat(caveatId, verificationId, loc)
 }
 
-// bndForRequest binds the given macaroon
+// zeroKey is the key used for binding discharge macaroons.
+var zeroKey [keyLen]byte
+
+// bindForRequest binds the given macaroon
 // to the given signature of its parent macaroon.
 func bindForRequest(rootSig, dischargeSig []byte) []byte {
 	if bytes.Equal(rootSig, dischargeSig) {
 		return rootSig
 	}
-	sig := sha256.New()
-	sig.Write(rootSig)
-	sig.Write(dischargeSig)
-	return sig.Sum(nil)
+	return keyedHash2(zeroKey[:], rootSig, dischargeSig)
 }
 
 // Verify verifies that the receiving macaroon is valid.
@@ -203,18 +260,110 @@ func bindForRequest(rootSig, dischargeSig []byte) []byte {
 // condition is not met.
 //
 // The discharge macaroons should be provided in discharges.
+// Discharges nested more deeply than DefaultLimits.MaxDepth
+// are rejected.
 //
 // Verify returns nil if the verification succeeds.
 func (m *Macaroon) Verify(rootKey []byte, check func(caveat string) error, discharges []*Macaroon) error {
+	return m.VerifyWithOptions(rootKey, check, discharges, nil)
+}
+
+// VerifyOptions holds optional parameters for VerifyWithOptions.
+type VerifyOptions struct {
+	// Limits holds the limits to apply during verification.
+	// If it is nil, DefaultLimits is used.
+	Limits *Limits
+
+	// Revocation, if non-nil, is consulted for the id of
+	// every macaroon in the chain and for every first party
+	// caveat condition before it is checked. Verification
+	// fails with a *RevokedError if any of them has
+	// been revoked.
+	Revocation RevocationChecker
+
+	// Cache, if non-nil, records successful verifications.
+	// When the same macaroon and discharges are verified
+	// again with the same root key and Limits, the signature
+	// checks are skipped and only the first party caveats
+	// for which Dynamic returns true are checked again.
+	// Revocation is always checked. Checker.Verify also
+	// checks the conditions that its checker reports
+	// as dynamic; see Checker.IsDynamic.
+	Cache *VerifyCache
+
+	// Dynamic reports whether the result of checking the
+	// given first party caveat condition may change between
+	// verifications, for example because it depends on the
+	// current time or on the request being authorized.
+	// It is only used when Cache is non-nil. If it is nil,
+	// all conditions are treated as dynamic.
+	Dynamic func(caveat string) bool
+
+	// Workers holds the maximum number of goroutines that
+	// will be used to verify independent discharge macaroon
+	// sub-trees concurrently. If it is less than 2, discharges
+	// are verified sequentially. When it is 2 or more, the
+	// check function and Revocation must be safe to call
+	// concurrently; the check functions used by Checker
+	// are.
+	Workers int
+}
+
+// VerifyWithOptions is like Verify except that it allows
+// additional options to be specified. If opts is nil,
+// it behaves exactly like Verify.
+func (m *Macaroon) VerifyWithOptions(rootKey []byte, check func(caveat string) error, discharges []*Macaroon, opts *VerifyOptions) error {
+	return m.verifyWithOptions(rootKey, check, nil, discharges, opts)
+}
+
+// caveatChecker is used by Checker.Verify to check first party
+// caveats, and to report whether they are dynamic, given the
+// macaroon holding each one and the index of its caveat.
+type caveatChecker struct {
+	check func(m *Macaroon, i int, caveat string) error
+
+	// dynamic is used in place of VerifyOptions.Dynamic
+	// if it is non-nil.
+	dynamic func(m *Macaroon, i int, caveat string) bool
+}
+
+// verifyWithOptions implements VerifyWithOptions.
+// If cc is non-nil, it is used instead of check
+// (which may then be nil) and VerifyOptions.Dynamic.
+func (m *Macaroon) verifyWithOptions(rootKey []byte, check func(caveat string) error, cc *caveatChecker, discharges []*Macaroon, opts *VerifyOptions) error {
 	// TODO(rog) consider distinguishing between classes of
 	// check error - some errors may be resolved by minting
 	// a new macaroon; others may not.
-	used := make([]int, len(discharges))
-	if err := m.verify(m.sig, rootKey, check, discharges, used); err != nil {
+	v := newVerifier(check, discharges, opts)
+	v.cc = cc
+	defer v.release()
+	var cache *VerifyCache
+	var cacheKey verifyCacheKey
+	if opts != nil {
+		cache = opts.Cache
+	}
+	if cache != nil {
+		cacheKey = newVerifyCacheKey(rootKey, v.limits, m, discharges)
+		if cache.contains(cacheKey) {
+			return m.verifyCached(v)
+		}
+	}
+	sc := getScratch()
+	sc.keyedHash(&sc.rootKey, keyGenerator, rootKey)
+	err := m.verify(v, m.sig, sc.rootKey[:], 0)
+	putScratch(sc)
+	if err != nil {
 		return err
 	}
+	// Report a missing discharge only once the signature and
+	// all the caveats that could be checked have been verified,
+	// so that the caller knows that acquiring the discharge
+	// may be enough to make verification succeed.
+	if derr := v.missing.Load(); derr != nil {
+		return derr
+	}
 	for i, dm := range discharges {
-		switch used[i] {
+		switch v.used[i] {
 		case 0:
 			return fmt.Errorf("discharge macaroon %q was not used", dm.Id())
 		case 1:
@@ -224,61 +373,294 @@ func (m *Macaroon) Verify(rootKey []byte, check func(caveat string) error, disch
 			return fmt.Errorf("discharge macaroon %q was used more than once", dm.Id())
 		}
 	}
+	if cache != nil {
+		cache.add(cacheKey)
+	}
 	return nil
 }
 
-func (m *Macaroon) verify(rootSig []byte, rootKey []byte, check func(caveat string) error, discharges []*Macaroon, used []int) error {
+// DischargeRequiredError is the error returned by Verify
+// when no discharge macaroon was provided for a third party caveat.
+// It is only returned when the signature and all the first party
+// caveats that could be checked without the discharge have been
+// verified successfully.
+type DischargeRequiredError struct {
+	// CaveatId holds the id of the undischarged caveat.
+	CaveatId string
+
+	// Location holds the location hint of the caveat.
+	Location string
+}
+
+func (e *DischargeRequiredError) Error() string {
+	return fmt.Sprintf("cannot find discharge macaroon for caveat %q", e.CaveatId)
+}
+
+// verifier holds the state for a single call to VerifyWithOptions.
+type verifier struct {
+	check      func(caveat string) error
+	dynamic    func(caveat string) bool
+	cc         *caveatChecker
+	discharges []*Macaroon
+	limits     *Limits
+	revocation RevocationChecker
+
+	// used holds the number of times each discharge
+	// has been used. It is updated atomically.
+	used []int32
+
+	// index maps from discharge macaroon id to the index
+	// in discharges of the first discharge with that id.
+	index map[string]int
+
+	// workers holds a token for each goroutine that
+	// may be started to verify discharges concurrently.
+	// It is nil if discharges are verified sequentially.
+	workers chan struct{}
+
+	// missing holds the error for the first third party
+	// caveat found with no discharge macaroon.
+	missing atomic.Pointer[DischargeRequiredError]
+}
+
+// verifierPool holds verifiers that are not in use, so
+// that verification does not need to allocate them.
+var verifierPool = sync.Pool{
+	New: func() interface{} {
+		return new(verifier)
+	},
+}
+
+// newVerifier returns a verifier from verifierPool, ready for
+// verifying a macaroon with the given discharges. The release
+// method should be called when it is no longer needed.
+func newVerifier(check func(caveat string) error, discharges []*Macaroon, opts *VerifyOptions) *verifier {
+	v := verifierPool.Get().(*verifier)
+	v.check = check
+	v.discharges = discharges
+	v.limits = &DefaultLimits
+	if opts != nil {
+		if opts.Limits != nil {
+			v.limits = opts.Limits
+		}
+		v.revocation = opts.Revocation
+		v.dynamic = opts.Dynamic
+		if opts.Workers > 1 {
+			// The calling goroutine counts as one of the workers.
+			v.workers = make(chan struct{}, opts.Workers-1)
+		}
+	}
+	if cap(v.used) < len(discharges) {
+		v.used = make([]int32, len(discharges))
+	} else {
+		v.used = v.used[0:len(discharges)]
+		for i := range v.used {
+			v.used[i] = 0
+		}
+	}
+	if len(discharges) > 0 {
+		if v.index == nil {
+			v.index = make(map[string]int, len(discharges))
+		}
+		// Iterate backwards so that the first
+		// discharge with a given id wins.
+		for i := len(discharges) - 1; i >= 0; i-- {
+			dm := discharges[i]
+			v.index[dm.dataStrNoCopy(dm.id)] = i
+		}
+	}
+	return v
+}
+
+// release returns v to verifierPool. The verifier
+// must not be used after calling release.
+func (v *verifier) release() {
+	for id := range v.index {
+		delete(v.index, id)
+	}
+	*v = verifier{
+		used:  v.used[:0],
+		index: v.index,
+	}
+	verifierPool.Put(v)
+}
+
+// checkCaveat checks the first party caveat with
+// the given index and condition in m.
+func (v *verifier) checkCaveat(m *Macaroon, i int, cond string) error {
+	if v.cc != nil {
+		return v.cc.check(m, i, cond)
+	}
+	return v.check(cond)
+}
+
+// isDynamic reports whether the first party caveat with the given
+// index and condition in m must be checked again on a cache hit.
+func (v *verifier) isDynamic(m *Macaroon, i int, cond string) bool {
+	if v.cc != nil && v.cc.dynamic != nil {
+		return v.cc.dynamic(m, i, cond)
+	}
+	return v.dynamic == nil || v.dynamic(cond)
+}
+
+// discharge returns the index of the discharge
+// macaroon with the given id.
+func (v *verifier) discharge(id []byte) (int, bool) {
+	i, ok := v.index[string(id)]
+	return i, ok
+}
+
+// startWorker reports whether a new goroutine may be started
+// to verify a discharge. If it returns true, stopWorker must
+// be called when the goroutine has finished.
+func (v *verifier) startWorker() bool {
+	select {
+	case v.workers <- struct{}{}:
+		return true
+	default:
+		// Sequential verification (v.workers is nil)
+		// or all workers are busy.
+		return false
+	}
+}
+
+func (v *verifier) stopWorker() {
+	<-v.workers
+}
+
+// pendingDischarges holds the discharge sub-trees of a single
+// macaroon that are being verified in other goroutines.
+type pendingDischarges struct {
+	wg sync.WaitGroup
+
+	mu sync.Mutex
+	// err holds the error from the sub-tree of the
+	// earliest caveat that failed; errIndex holds
+	// the index of that caveat.
+	err      error
+	errIndex int
+}
+
+func (p *pendingDischarges) setError(i int, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.err == nil || i < p.errIndex {
+		p.err, p.errIndex = err, i
+	}
+}
+
+// wait waits for all the sub-trees to be verified and
+// returns any error.
+func (p *pendingDischarges) wait() error {
+	p.wg.Wait()
+	return p.err
+}
+
+func (m *Macaroon) verify(v *verifier, rootSig []byte, rootKey []byte, depth int) error {
+	if err := v.limits.checkDepth(depth); err != nil {
+		return err
+	}
 	if len(rootSig) == 0 {
 		rootSig = m.sig
 	}
-	caveatSig := keyedHash(rootKey, m.dataBytes(m.id))
-	for i, cav := range m.caveats {
+	if v.revocation != nil {
+		kind := RevokedDischargeId
+		if depth == 0 {
+			kind = RevokedMacaroonId
+		}
+		if err := checkRevoked(v.revocation, kind, m.Id()); err != nil {
+			return err
+		}
+	}
+	sc := getScratch()
+	defer putScratch(sc)
+	pending, err := m.verifyCaveats(v, sc, rootSig, rootKey, depth)
+	// Always wait for the other goroutines, even on error,
+	// so that none of them are still running when
+	// verification returns.
+	if pending != nil {
+		if perr := pending.wait(); err == nil {
+			err = perr
+		}
+	}
+	if err != nil {
+		return err
+	}
+	// TODO perhaps we should actually do this check before doing
+	// all the potentially expensive caveat checks.
+	if !bytes.Equal(rootSig, sc.sig[:]) {
+		// Bind the signature as bindForRequest does.
+		sc.keyedHash2(&sc.sig, zeroKey[:], rootSig, sc.sig[:])
+	}
+	if !hmac.Equal(sc.sig[:], m.sig) {
+		return fmt.Errorf("signature mismatch after caveat verification")
+	}
+	return nil
+}
+
+// verifyCaveats checks all the caveats in m, leaving the resulting
+// signature in sc.sig. Discharge sub-trees may be verified in other
+// goroutines, in which case the returned pendingDischarges is non-nil
+// and must be waited for.
+func (m *Macaroon) verifyCaveats(v *verifier, sc *scratch, rootSig []byte, rootKey []byte, depth int) (*pendingDischarges, error) {
+	var pending *pendingDischarges
+	sc.keyedHash(&sc.sig, rootKey, m.dataBytes(m.id))
+	for i := range m.caveats {
+		cav := &m.caveats[i]
 		if cav.isThirdParty() {
-			cavKey, err := decrypt(caveatSig, m.dataBytes(cav.verificationId))
+			cavKey, err := decrypt(sc.sig[:], m.dataBytes(cav.verificationId))
 			if err != nil {
-				return fmt.Errorf("failed to decrypt caveat %d signature: %v", i, err)
+				return pending, fmt.Errorf("failed to decrypt caveat %d signature: %v", i, err)
 			}
-			// We choose an arbitrary error from one of the
-			// possible discharge macaroon verifications
-			// if there's more than one discharge macaroon
-			// with the required id.
-			found := false
-			for di, dm := range discharges {
-				if !bytes.Equal(dm.dataBytes(dm.id), m.dataBytes(cav.caveatId)) {
-					continue
-				}
-				found = true
-
-				// It's important that we do this before calling verify,
-				// as it prevents potentially infinite recursion.
-				if used[di]++; used[di] > 1 {
-					return fmt.Errorf("discharge macaroon %q was used more than once", dm.Id())
-				}
-				if err := dm.verify(rootSig, cavKey, check, discharges, used); err != nil {
-					return err
-				}
-				break
+			// If there's more than one discharge macaroon with
+			// the required id, we use the first one; the others
+			// will be reported as unused.
+			di, ok := v.discharge(m.dataBytes(cav.caveatId))
+			if !ok {
+				// Carry on so that the signature and the
+				// remaining caveats are still checked.
+				v.missing.CompareAndSwap(nil, &DischargeRequiredError{
+					CaveatId: m.dataStr(cav.caveatId),
+					Location: m.dataStr(cav.location),
+				})
+				m.scratchCaveatSig(sc, cav)
+				continue
 			}
-			if !found {
-				return fmt.Errorf("cannot find discharge macaroon for caveat %q", m.dataBytes(cav.caveatId))
+			dm := v.discharges[di]
+			// It's important that we do this before calling verify,
+			// as it prevents potentially infinite recursion.
+			if atomic.AddInt32(&v.used[di], 1) > 1 {
+				return pending, fmt.Errorf("discharge macaroon %q was used more than once", dm.Id())
+			}
+			if v.startWorker() {
+				if pending == nil {
+					pending = new(pendingDischarges)
+				}
+				pending.wg.Add(1)
+				go func(p *pendingDischarges, i int) {
+					defer p.wg.Done()
+					defer v.stopWorker()
+					if err := dm.verify(v, rootSig, cavKey, depth+1); err != nil {
+						p.setError(i, err)
+					}
+				}(pending, i)
+			} else if err := dm.verify(v, rootSig, cavKey, depth+1); err != nil {
+				return pending, err
 			}
 		} else {
-			if err := check(string(m.dataBytes(cav.caveatId))); err != nil {
-				return err
+			cond := m.dataStrNoCopy(cav.caveatId)
+			if v.revocation != nil {
+				if err := checkRevoked(v.revocation, RevokedCondition, cond); err != nil {
+					return pending, err
+				}
+			}
+			if err := v.checkCaveat(m, i, cond); err != nil {
+				return pending, err
 			}
 		}
-		sig := keyedHasher(caveatSig)
-		sig.Write(m.dataBytes(cav.verificationId))
-		sig.Write(m.dataBytes(cav.caveatId))
-		caveatSig = sig.Sum(caveatSig[:0])
+		m.scratchCaveatSig(sc, cav)
 	}
-	// TODO perhaps we should actually do this check before doing
-	// all the potentially expensive caveat checks.
-	boundSig := bindForRequest(rootSig, caveatSig)
-	if !hmac.Equal(boundSig, m.sig) {
-		return fmt.Errorf("signature mismatch after caveat verification")
-	}
-	return nil
+	return pending, nil
 }
 
 type Verifier interface {
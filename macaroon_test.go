@@ -14,13 +14,37 @@ import (
 
 func TestMacaroonLength(t *testing.T) {
 	m, _ := macaroon.New([]byte("secret"), "", "")
-	const expectedLength = 29
+	const expectedLength = 41
 	buf, _ := m.MarshalBinary()
 	if n := len(buf); n != expectedLength {
 		t.Errorf("expected length %v; got %v\n", expectedLength, n)
 	}
 }
 
+func TestVerifyDoesNotAllocate(t *testing.T) {
+	if raceEnabled {
+		t.Skip("sync.Pool does not reliably reuse values when the race detector is enabled")
+	}
+	rootKey := []byte("secret")
+	m, _ := macaroon.New(rootKey, "some id", "a location")
+	for _, cond := range []string{"a", "b", "c", "d"} {
+		if err := m.AddFirstPartyCaveat(cond); err != nil {
+			t.Fatal(err)
+		}
+	}
+	check := func(string) error {
+		return nil
+	}
+	allocs := testing.AllocsPerRun(100, func() {
+		if err := m.Verify(rootKey, check, nil); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if allocs != 0 {
+		t.Errorf("Verify allocated %v times; want 0", allocs)
+	}
+}
+
 func TestPackage(t *testing.T) {
 	gc.TestingT(t)
 }
@@ -97,10 +121,26 @@ func (*macaroonSuite) TestThirdPartyCaveatBadRandom(c *gc.C) {
 	dischargeRootKey := []byte("shared root key")
 	thirdPartyCaveatId := "3rd party caveat"
 
-	err := macaroon.AddThirdPartyCaveatWithRand(m, dischargeRootKey, thirdPartyCaveatId, "remote.com", &macaroon.ErrorReader{})
+	err := m.AddThirdPartyCaveatWithRand(dischargeRootKey, thirdPartyCaveatId, "remote.com", &macaroon.ErrorReader{})
 	c.Assert(err, gc.ErrorMatches, "cannot generate random bytes: fail")
 }
 
+func (*macaroonSuite) TestThirdPartyCaveatWithRandIsReproducible(c *gc.C) {
+	mint := func() *macaroon.Macaroon {
+		m := MustNew([]byte("secret"), "some id", "a location")
+		err := m.AddThirdPartyCaveatWithRand([]byte("shared root key"), "3rd party caveat", "remote.com", zeroReader{})
+		c.Assert(err, gc.IsNil)
+		return m
+	}
+	m0, m1 := mint(), mint()
+	assertEqualMacaroons(c, m0, m1)
+
+	dm := MustNew([]byte("shared root key"), "3rd party caveat", "remote.com")
+	dm.Bind(m0.Signature())
+	err := m1.Verify([]byte("secret"), never, []*macaroon.Macaroon{dm})
+	c.Assert(err, gc.IsNil)
+}
+
 type conditionTest struct {
 	conditions map[string]bool
 	expectErr  string
@@ -489,10 +529,47 @@ func (*macaroonSuite) TestVerify(c *gc.C) {
 			// Cloned macaroon should have same verify result.
 			cloneErr := primary.Clone().Verify(rootKey, check, discharges)
 			c.Assert(cloneErr, gc.DeepEquals, err)
+
+			// So should concurrent verification.
+			concurrentErr := primary.VerifyWithOptions(rootKey, check, discharges, &macaroon.VerifyOptions{
+				Workers: 4,
+			})
+			if cond.expectErr != "" {
+				c.Assert(concurrentErr, gc.ErrorMatches, cond.expectErr)
+			} else {
+				c.Assert(concurrentErr, gc.IsNil)
+			}
 		}
 	}
 }
 
+func (*macaroonSuite) TestVerifyConcurrentDischargeUsedTwice(c *gc.C) {
+	// Two independent sub-trees both require the same
+	// discharge, which must be detected even when the
+	// sub-trees are verified concurrently.
+	rootKey := []byte("root-key")
+	m := MustNew(rootKey, "root-id", "")
+	for _, id := range []string{"bob", "charlie"} {
+		err := m.AddThirdPartyCaveat([]byte(id+"-key"), id, id)
+		c.Assert(err, gc.IsNil)
+	}
+	bob := MustNew([]byte("bob-key"), "bob", "bob")
+	err := bob.AddThirdPartyCaveat([]byte("shared-key"), "shared", "shared")
+	c.Assert(err, gc.IsNil)
+	charlie := MustNew([]byte("charlie-key"), "charlie", "charlie")
+	err = charlie.AddThirdPartyCaveat([]byte("shared-key"), "shared", "shared")
+	c.Assert(err, gc.IsNil)
+	shared := MustNew([]byte("shared-key"), "shared", "shared")
+	s := macaroon.Slice{m, bob, charlie, shared}
+	s.Bind()
+	for i := 0; i < 20; i++ {
+		err = m.VerifyWithOptions(rootKey, never, s[1:], &macaroon.VerifyOptions{
+			Workers: 4,
+		})
+		c.Assert(err, gc.ErrorMatches, `discharge macaroon "shared" was used more than once`)
+	}
+}
+
 func (*macaroonSuite) TestMarshalJSON(c *gc.C) {
 	rootKey := []byte("secret")
 	m0 := MustNew(rootKey, "some id", "a location")
@@ -512,16 +589,14 @@ func (*macaroonSuite) TestMarshalJSON(c *gc.C) {
 
 func (*macaroonSuite) TestJSONRoundTrip(c *gc.C) {
 	// jsonData produced from the second example in libmacaroons
-	// example README, but with the signature tweaked to
-	// match our current behaviour.
-	// TODO fix that behaviour so that our signatures match.
-	jsonData := `{"caveats":[{"cid":"account = 3735928559"},{"cid":"this was how we remind auth of key\/pred","vid":"AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA027FAuBYhtHwJ58FX6UlVNFtFsGxQHS7uD\/w\/dedwv4Jjw7UorCREw5rXbRqIKhr","cl":"http:\/\/auth.mybank\/"}],"location":"http:\/\/mybank\/","identifier":"we used our other secret key","signature":"6e315b0b391e8c6cc6f8d88fc22933a13430fb289b2fb613cf70f746bbe7d27d"}`
+	// example README.
+	jsonData := `{"caveats":[{"cid":"account = 3735928559"},{"cid":"this was how we remind auth of key\/pred","vid":"AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA027FAuBYhtHwJ58FX6UlVNFtFsGxQHS7uD\/w\/dedwv4Jjw7UorCREw5rXbRqIKhr","cl":"http:\/\/auth.mybank\/"}],"location":"http:\/\/mybank\/","identifier":"we used our other secret key","signature":"d27db2fd1f22760e4c3dae8137e2d8fc1df6c0741c18aed4b97256bf78d1f55c"}`
 
 	var m macaroon.Macaroon
 	err := json.Unmarshal([]byte(jsonData), &m)
 	c.Assert(err, gc.IsNil)
 	c.Assert(hex.EncodeToString(m.Signature()), gc.Equals,
-		"6e315b0b391e8c6cc6f8d88fc22933a13430fb289b2fb613cf70f746bbe7d27d")
+		"d27db2fd1f22760e4c3dae8137e2d8fc1df6c0741c18aed4b97256bf78d1f55c")
 	data, err := m.MarshalJSON()
 	c.Assert(err, gc.IsNil)
 
@@ -621,8 +696,10 @@ func (*macaroonSuite) TestMacaroonFieldsTooBig(c *gc.C) {
 	c.Assert(err, gc.ErrorMatches, "macaroon location too big")
 
 	m0 := MustNew(rootKey, "some id", "a location")
+	// The third party root key is hashed before encryption,
+	// so its size does not affect the verification id.
 	err = m0.AddThirdPartyCaveat(toobig, "3rd party caveat", "remote.com")
-	c.Assert(err, gc.ErrorMatches, "caveat verification id too big")
+	c.Assert(err, gc.IsNil)
 	err = m0.AddThirdPartyCaveat([]byte("shared root key"), string(toobig), "remote.com")
 	c.Assert(err, gc.ErrorMatches, "caveat identifier too big")
 	err = m0.AddThirdPartyCaveat([]byte("shared root key"), "3rd party caveat", string(toobig))
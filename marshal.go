@@ -1,6 +1,7 @@
 package macaroon
 
 import (
+	"bytes"
 	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
@@ -75,12 +76,26 @@ func (m *Macaroon) MarshalJSON() ([]byte, error) {
 }
 
 // UnmarshalJSON implements json.Unmarshaler.
+// The data is checked against DefaultLimits.
 func (m *Macaroon) UnmarshalJSON(jsonData []byte) error {
+	return m.unmarshalJSON(jsonData, &DefaultLimits)
+}
+
+func (m *Macaroon) unmarshalJSON(jsonData []byte, limits *Limits) error {
+	if err := limits.checkSize(len(jsonData)); err != nil {
+		return err
+	}
 	var mjson macaroonJSON
 	err := json.Unmarshal(jsonData, &mjson)
 	if err != nil {
 		return fmt.Errorf("cannot unmarshal json data: %v", err)
 	}
+	if err := limits.checkCaveats(len(mjson.Caveats)); err != nil {
+		return err
+	}
+	if err := limits.checkIdLen(len(mjson.Identifier)); err != nil {
+		return err
+	}
 	if err := m.init(mjson.Identifier, mjson.Location); err != nil {
 		return err
 	}
@@ -90,6 +105,9 @@ func (m *Macaroon) UnmarshalJSON(jsonData []byte) error {
 	}
 	m.caveats = m.caveats[:0]
 	for _, cav := range mjson.Caveats {
+		if err := limits.checkIdLen(len(cav.CID)); err != nil {
+			return err
+		}
 		vid, err := base64.StdEncoding.DecodeString(cav.VID)
 		if err != nil {
 			return fmt.Errorf("cannot decode verification id %q: %v", cav.VID, err)
@@ -121,8 +139,9 @@ func (m *Macaroon) MarshalBinary() ([]byte, error) {
 
 // unmarshalBinaryNoCopy is the internal implementation of
 // UnmarshalBinary. It differs in that it does not copy the
-// data.
-func (m *Macaroon) unmarshalBinaryNoCopy(data []byte) error {
+// data. The caller is responsible for checking the
+// overall size of the data.
+func (m *Macaroon) unmarshalBinaryNoCopy(data []byte, limits *Limits) error {
 	m.data = data
 	var err error
 	var start int
@@ -135,6 +154,9 @@ func (m *Macaroon) unmarshalBinaryNoCopy(data []byte) error {
 	if err != nil {
 		return err
 	}
+	if err := limits.checkIdLen(len(m.dataBytes(m.id))); err != nil {
+		return err
+	}
 	var cav caveat
 	for {
 		p, err := m.parsePacket(start)
@@ -155,16 +177,29 @@ func (m *Macaroon) unmarshalBinaryNoCopy(data []byte) error {
 		case fieldCaveatId:
 			if cav.caveatId.len() != 0 {
 				m.caveats = append(m.caveats, cav)
+				cav = caveat{}
+			}
+			if err := limits.checkCaveats(len(m.caveats) + 1); err != nil {
+				return err
+			}
+			if err := limits.checkIdLen(len(m.dataBytes(p))); err != nil {
+				return err
 			}
 			cav.caveatId = p
 		case fieldVerificationId:
+			if cav.caveatId.len() == 0 {
+				return fmt.Errorf("field %v found before caveat id", fieldVerificationId)
+			}
 			if cav.verificationId.len() != 0 {
 				return fmt.Errorf("repeated field %v in caveat", fieldVerificationId)
 			}
 			cav.verificationId = p
 		case fieldCaveatLocation:
+			if cav.caveatId.len() == 0 {
+				return fmt.Errorf("field %v found before caveat id", fieldCaveatLocation)
+			}
 			if cav.location.len() != 0 {
-				return fmt.Errorf("repeated field %v in caveat", fieldLocation)
+				return fmt.Errorf("repeated field %v in caveat", fieldCaveatLocation)
 			}
 			cav.location = p
 		default:
@@ -174,9 +209,11 @@ func (m *Macaroon) unmarshalBinaryNoCopy(data []byte) error {
 }
 
 // UnmarshalBinary implements encoding.BinaryUnmarshaler.
+// The data may have been compressed by MarshalBinaryCompressed.
+// The data is checked against DefaultLimits, both before and
+// after decompression.
 func (m *Macaroon) UnmarshalBinary(data []byte) error {
-	data = append([]byte(nil), data...)
-	return m.unmarshalBinaryNoCopy(data)
+	return DefaultLimits.DecodeBinary(m, data)
 }
 
 func (m *Macaroon) expectPacket(start int, kind field) (int, packet, error) {
@@ -226,14 +263,28 @@ func (s Slice) MarshalBinary() ([]byte, error) {
 }
 
 // UnmarshalBinary implements encoding.BinaryUnmarshaler.
+// The data may have been compressed by MarshalBinaryCompressed.
+// The data is checked against DefaultLimits, both before and
+// after decompression.
 func (s *Slice) UnmarshalBinary(data []byte) error {
-	data = append([]byte(nil), data...)
+	return s.unmarshalBinary(data, &DefaultLimits)
+}
+
+func (s *Slice) unmarshalBinary(data []byte, limits *Limits) error {
+	data, err := limits.decodeData(data)
+	if err != nil {
+		return err
+	}
 	*s = (*s)[:0]
 	for len(data) > 0 {
+		// All macaroons after the first are discharges.
+		if err := limits.checkDischarges(len(*s)); err != nil {
+			return err
+		}
 		var m Macaroon
-		err := m.unmarshalBinaryNoCopy(data)
+		err := m.unmarshalBinaryNoCopy(data, limits)
 		if err != nil {
-			return fmt.Errorf("cannot unmarshal macaroon: %v", err)
+			return fmt.Errorf("cannot unmarshal macaroon: %w", err)
 		}
 		*s = append(*s, &m)
 		// Prevent the macaroon from overwriting the other ones
@@ -243,3 +294,110 @@ func (s *Slice) UnmarshalBinary(data []byte) error {
 	}
 	return nil
 }
+
+// MarshalJSON implements json.Marshaler. The macaroons
+// are encoded as a JSON array of macaroon objects.
+func (s Slice) MarshalJSON() ([]byte, error) {
+	if s == nil {
+		s = Slice{}
+	}
+	return json.Marshal([]*Macaroon(s))
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+// The data is checked against DefaultLimits.
+func (s *Slice) UnmarshalJSON(data []byte) error {
+	return s.unmarshalJSON(data, &DefaultLimits)
+}
+
+func (s *Slice) unmarshalJSON(data []byte, limits *Limits) error {
+	if err := limits.checkSize(len(data)); err != nil {
+		return err
+	}
+	var items []json.RawMessage
+	if err := json.Unmarshal(data, &items); err != nil {
+		return fmt.Errorf("cannot unmarshal json data: %v", err)
+	}
+	if err := limits.checkDischarges(len(items) - 1); err != nil {
+		return err
+	}
+	s1 := make(Slice, len(items))
+	for i, item := range items {
+		if string(item) == "null" {
+			return fmt.Errorf("null macaroon at index %d", i)
+		}
+		var m Macaroon
+		if err := m.unmarshalJSON(item, limits); err != nil {
+			return fmt.Errorf("cannot unmarshal macaroon: %w", err)
+		}
+		s1[i] = &m
+	}
+	*s = s1
+	return nil
+}
+
+// MarshalText implements encoding.TextMarshaler. The
+// binary form of the macaroon is encoded as URL-safe
+// base64 without padding.
+func (m *Macaroon) MarshalText() ([]byte, error) {
+	data, err := m.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	return encodeBase64(data), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+// Standard and padded base64 are accepted as well as
+// the form produced by MarshalText.
+func (m *Macaroon) UnmarshalText(text []byte) error {
+	data, err := decodeBase64(text)
+	if err != nil {
+		return err
+	}
+	return m.UnmarshalBinary(data)
+}
+
+// MarshalText implements encoding.TextMarshaler. The
+// binary form of the macaroons is encoded as URL-safe
+// base64 without padding.
+func (s Slice) MarshalText() ([]byte, error) {
+	data, err := s.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	return encodeBase64(data), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+// Standard and padded base64 are accepted as well as
+// the form produced by MarshalText.
+func (s *Slice) UnmarshalText(text []byte) error {
+	data, err := decodeBase64(text)
+	if err != nil {
+		return err
+	}
+	return s.UnmarshalBinary(data)
+}
+
+func encodeBase64(data []byte) []byte {
+	text := make([]byte, base64.RawURLEncoding.EncodedLen(len(data)))
+	base64.RawURLEncoding.Encode(text, data)
+	return text
+}
+
+// decodeBase64 decodes base64 text in either the URL-safe
+// or the standard alphabet, with or without padding.
+func decodeBase64(text []byte) ([]byte, error) {
+	enc := base64.RawURLEncoding
+	if bytes.ContainsAny(text, "+/") {
+		enc = base64.RawStdEncoding
+	}
+	text = bytes.TrimRight(text, "=")
+	data := make([]byte, enc.DecodedLen(len(text)))
+	n, err := enc.Decode(data, text)
+	if err != nil {
+		return nil, fmt.Errorf("cannot decode base64 macaroon data: %v", err)
+	}
+	return data[0:n], nil
+}
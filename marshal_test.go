@@ -1,6 +1,9 @@
 package macaroon_test
 
 import (
+	"encoding/base64"
+	"encoding/json"
+
 	gc "gopkg.in/check.v1"
 
 	"github.com/iron-io/macaroon"
@@ -94,3 +97,163 @@ func (*marshalSuite) TestSliceRoundtrip(c *gc.C) {
 
 	c.Assert(b, gc.DeepEquals, marshaledMacs)
 }
+
+func (*marshalSuite) TestUnmarshalBinaryCaveatOrder(c *gc.C) {
+	// A first party caveat following a third party caveat
+	// must not inherit any fields from it.
+	rootKey := []byte("secret")
+	m0 := MustNew(rootKey, "some id", "a location")
+	err := m0.AddThirdPartyCaveat([]byte("shared root key"), "3rd party caveat", "remote.com")
+	c.Assert(err, gc.IsNil)
+	err = m0.AddFirstPartyCaveat("first caveat")
+	c.Assert(err, gc.IsNil)
+	err = m0.AddFirstPartyCaveat("")
+	c.Assert(err, gc.IsNil)
+
+	data, err := m0.MarshalBinary()
+	c.Assert(err, gc.IsNil)
+	var m1 macaroon.Macaroon
+	err = m1.UnmarshalBinary(data)
+	c.Assert(err, gc.IsNil)
+	assertEqualMacaroons(c, m0, &m1)
+	c.Assert(m1.Caveats(), gc.DeepEquals, []macaroon.Caveat{{
+		Id:       "3rd party caveat",
+		Location: "remote.com",
+	}, {
+		Id: "first caveat",
+	}, {
+		Id: "",
+	}})
+	err = m1.Verify(rootKey, func(string) error { return nil }, nil)
+	c.Assert(err, gc.ErrorMatches, `cannot find discharge macaroon for caveat "3rd party caveat"`)
+}
+
+var unmarshalBinaryErrorTests = []struct {
+	about     string
+	data      string
+	expectErr string
+}{{
+	about:     "empty data",
+	data:      "",
+	expectErr: "packet too short",
+}, {
+	about: "zero packet size",
+	// A zero size at the start of the data marks it
+	// as compressed, so put the zero size later.
+	data:      "\x03\x00\x01\x00\x00\x02",
+	expectErr: "packet size too small",
+}, {
+	about:     "unknown compression method",
+	data:      "\x00\x00\x7f",
+	expectErr: "unknown macaroon compression method 127",
+}, {
+	about:     "packet size larger than data",
+	data:      "\x10\x00\x01",
+	expectErr: "packet size too big",
+}, {
+	about:     "verification id without caveat id",
+	data:      "\x03\x00\x01\x03\x00\x02\x03\x00\x05",
+	expectErr: "field vid found before caveat id",
+}, {
+	about:     "caveat location without caveat id",
+	data:      "\x03\x00\x01\x03\x00\x02\x03\x00\x06",
+	expectErr: "field cl found before caveat id",
+}, {
+	about:     "repeated caveat location",
+	data:      "\x03\x00\x01\x03\x00\x02\x03\x00\x04\x03\x00\x06\x03\x00\x06",
+	expectErr: "repeated field cl in caveat",
+}}
+
+func (*marshalSuite) TestUnmarshalBinaryErrors(c *gc.C) {
+	for i, test := range unmarshalBinaryErrorTests {
+		c.Logf("test %d: %s", i, test.about)
+		var m macaroon.Macaroon
+		err := m.UnmarshalBinary([]byte(test.data))
+		c.Assert(err, gc.ErrorMatches, test.expectErr)
+	}
+}
+
+func (*marshalSuite) TestSliceJSONRoundTrip(c *gc.C) {
+	rootKey := []byte("secret")
+	s := thirdPartySlice(c, rootKey)
+	s.Bind()
+
+	data, err := json.Marshal(s)
+	c.Assert(err, gc.IsNil)
+	var items []json.RawMessage
+	err = json.Unmarshal(data, &items)
+	c.Assert(err, gc.IsNil)
+	c.Assert(items, gc.HasLen, 3)
+	data0, err := json.Marshal(s[0])
+	c.Assert(err, gc.IsNil)
+	c.Assert(string(items[0]), gc.Equals, string(data0))
+
+	var s1 macaroon.Slice
+	err = json.Unmarshal(data, &s1)
+	c.Assert(err, gc.IsNil)
+	c.Assert(s1, gc.HasLen, 3)
+	for i := range s {
+		assertEqualMacaroons(c, s[i], s1[i])
+	}
+	err = s1.Verify(rootKey, never)
+	c.Assert(err, gc.IsNil)
+
+	data, err = json.Marshal(macaroon.Slice(nil))
+	c.Assert(err, gc.IsNil)
+	c.Assert(string(data), gc.Equals, "[]")
+}
+
+func (*marshalSuite) TestSliceUnmarshalJSONErrors(c *gc.C) {
+	var s macaroon.Slice
+	err := json.Unmarshal([]byte(`{}`), &s)
+	c.Assert(err, gc.ErrorMatches, `cannot unmarshal json data: .*`)
+	err = json.Unmarshal([]byte(`[{"signature": "xx"}]`), &s)
+	c.Assert(err, gc.ErrorMatches, `cannot unmarshal macaroon: cannot decode macaroon signature .*`)
+	err = json.Unmarshal([]byte(`[null]`), &s)
+	c.Assert(err, gc.ErrorMatches, `null macaroon at index 0`)
+	c.Assert(s, gc.IsNil)
+}
+
+func (*marshalSuite) TestTextRoundTrip(c *gc.C) {
+	rootKey := []byte("secret")
+	s := thirdPartySlice(c, rootKey)
+	s.Bind()
+
+	text, err := s[0].MarshalText()
+	c.Assert(err, gc.IsNil)
+	c.Assert(string(text), gc.Not(gc.Matches), `.*[+/=].*`)
+	bin, err := s[0].MarshalBinary()
+	c.Assert(err, gc.IsNil)
+	c.Assert(string(text), gc.Equals, base64.RawURLEncoding.EncodeToString(bin))
+
+	for _, enc := range []*base64.Encoding{
+		base64.RawURLEncoding,
+		base64.URLEncoding,
+		base64.RawStdEncoding,
+		base64.StdEncoding,
+	} {
+		var m macaroon.Macaroon
+		err = m.UnmarshalText([]byte(enc.EncodeToString(bin)))
+		c.Assert(err, gc.IsNil)
+		assertEqualMacaroons(c, s[0], &m)
+	}
+
+	text, err = s.MarshalText()
+	c.Assert(err, gc.IsNil)
+	var s1 macaroon.Slice
+	err = s1.UnmarshalText(text)
+	c.Assert(err, gc.IsNil)
+	c.Assert(s1, gc.HasLen, 3)
+	err = s1.Verify(rootKey, never)
+	c.Assert(err, gc.IsNil)
+
+	bin, err = s.MarshalBinary()
+	c.Assert(err, gc.IsNil)
+	err = s1.UnmarshalText([]byte(base64.StdEncoding.EncodeToString(bin)))
+	c.Assert(err, gc.IsNil)
+	c.Assert(s1, gc.HasLen, 3)
+
+	var m macaroon.Macaroon
+	err = m.UnmarshalText([]byte("!!!"))
+	c.Assert(err, gc.ErrorMatches, `cannot decode base64 macaroon data: .*`)
+}
@@ -0,0 +1,263 @@
+package macaroon
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// CondNamespace is the condition name of
+// caveats created by NamespaceCaveat.
+const CondNamespace = "ns"
+
+// Namespace maps between the URIs that identify sets of caveat
+// conditions and the short prefixes used for them in caveats.
+// A condition in the namespace with prefix p is written as
+// "p:name arg".
+type Namespace struct {
+	uris     map[string]string // prefix -> URI
+	prefixes map[string]string // URI -> prefix
+}
+
+// NewNamespace returns a new, empty namespace.
+func NewNamespace() *Namespace {
+	return &Namespace{
+		uris:     make(map[string]string),
+		prefixes: make(map[string]string),
+	}
+}
+
+// Register associates the given prefix with the given URI. It
+// returns an error if either is invalid or is already associated
+// with something else.
+func (ns *Namespace) Register(uri, prefix string) error {
+	if uri == "" || strings.IndexByte(uri, ' ') >= 0 {
+		return fmt.Errorf("invalid namespace URI %q", uri)
+	}
+	if prefix == "" || strings.IndexAny(prefix, ": ") >= 0 {
+		return fmt.Errorf("invalid namespace prefix %q", prefix)
+	}
+	if old, ok := ns.uris[prefix]; ok && old != uri {
+		return fmt.Errorf("prefix %q is already used for %q", prefix, old)
+	}
+	if old, ok := ns.prefixes[uri]; ok && old != prefix {
+		return fmt.Errorf("namespace %q already has prefix %q", uri, old)
+	}
+	ns.uris[prefix] = uri
+	ns.prefixes[uri] = prefix
+	return nil
+}
+
+// URI returns the URI associated with the given prefix.
+func (ns *Namespace) URI(prefix string) (string, bool) {
+	if ns == nil {
+		return "", false
+	}
+	uri, ok := ns.uris[prefix]
+	return uri, ok
+}
+
+// Prefix returns the prefix associated with the given URI.
+func (ns *Namespace) Prefix(uri string) (string, bool) {
+	if ns == nil {
+		return "", false
+	}
+	prefix, ok := ns.prefixes[uri]
+	return prefix, ok
+}
+
+// Condition returns a first party caveat for the condition
+// with the given name and argument in the namespace
+// with the given URI.
+func (ns *Namespace) Condition(uri, name, arg string) (string, error) {
+	prefix, ok := ns.Prefix(uri)
+	if !ok {
+		return "", fmt.Errorf("no prefix registered for namespace %q", uri)
+	}
+	return Condition(prefix+":"+name, arg), nil
+}
+
+// String returns the namespace in the form used by
+// NamespaceCaveat: space-separated "prefix:uri" pairs,
+// sorted by prefix.
+func (ns *Namespace) String() string {
+	if ns == nil {
+		return ""
+	}
+	prefixes := make([]string, 0, len(ns.uris))
+	for prefix := range ns.uris {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Strings(prefixes)
+	var buf strings.Builder
+	for i, prefix := range prefixes {
+		if i > 0 {
+			buf.WriteByte(' ')
+		}
+		buf.WriteString(prefix)
+		buf.WriteByte(':')
+		buf.WriteString(ns.uris[prefix])
+	}
+	return buf.String()
+}
+
+// ParseNamespace parses a namespace in the form
+// returned by Namespace.String.
+func ParseNamespace(s string) (*Namespace, error) {
+	ns := NewNamespace()
+	if err := ns.merge(s); err != nil {
+		return nil, err
+	}
+	return ns, nil
+}
+
+// merge adds the associations in s, which is
+// in the form returned by String, to ns.
+func (ns *Namespace) merge(s string) error {
+	if s == "" {
+		return nil
+	}
+	for _, field := range strings.Split(s, " ") {
+		i := strings.IndexByte(field, ':')
+		if i < 0 {
+			return fmt.Errorf("no colon in namespace field %q", field)
+		}
+		if err := ns.Register(field[i+1:], field[0:i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// NamespaceCaveat returns a first party caveat that declares
+// the given namespace. When added to a macaroon, it tells
+// everyone that checks the caveats that follow it in the
+// macaroon what the prefixes in them mean. It does not apply
+// to earlier caveats, so a holder of the macaroon cannot
+// change their meaning by adding one. It is always satisfied.
+func NamespaceCaveat(ns *Namespace) string {
+	return Condition(CondNamespace, ns.String())
+}
+
+// InferNamespace returns the namespace declared by the namespace
+// caveats in the macaroons in s. It returns an error if the caveats
+// associate a prefix with more than one URI or the reverse.
+//
+// Note that Checker.Verify does not use the result to check
+// caveats; see NamespaceCaveat.
+func InferNamespace(s Slice) (*Namespace, error) {
+	ns := NewNamespace()
+	for _, m := range s {
+		for _, cav := range m.caveats {
+			if cav.isThirdParty() {
+				continue
+			}
+			name, arg, err := ParseCaveat(m.dataStr(cav.caveatId))
+			if err != nil || name != CondNamespace {
+				continue
+			}
+			if err := ns.merge(arg); err != nil {
+				return nil, fmt.Errorf("invalid namespace caveat in macaroon %q: %v", m.Id(), err)
+			}
+		}
+	}
+	return ns, nil
+}
+
+// clone returns a copy of ns.
+func (ns *Namespace) clone() *Namespace {
+	ns1 := NewNamespace()
+	for prefix, uri := range ns.uris {
+		ns1.uris[prefix] = uri
+		ns1.prefixes[uri] = prefix
+	}
+	return ns1
+}
+
+// namespaceScopes holds the namespaces declared by the namespace
+// caveats in each macaroon of a Slice, as used by Checker.Verify.
+type namespaceScopes map[*Macaroon][]namespaceScope
+
+// namespaceScope holds the namespace that applies to
+// the caveats after a namespace caveat in a macaroon.
+type namespaceScope struct {
+	// index holds the index of the namespace caveat.
+	index int
+
+	// ctx holds the context, derived from the one passed
+	// to newNamespaceScopes, holding the namespace declared
+	// by that caveat and the ones before it.
+	ctx context.Context
+}
+
+// newNamespaceScopes returns the namespace scopes of the macaroons
+// in s. It returns an error if the namespace caveats in a macaroon
+// associate a prefix with more than one URI or the reverse.
+func newNamespaceScopes(ctx context.Context, s Slice) (namespaceScopes, error) {
+	var scopes namespaceScopes
+	for _, m := range s {
+		var ns *Namespace
+		for i, cav := range m.caveats {
+			if cav.isThirdParty() {
+				continue
+			}
+			name, arg, err := ParseCaveat(m.dataStr(cav.caveatId))
+			if err != nil || name != CondNamespace {
+				continue
+			}
+			// The contexts of earlier scopes refer to ns,
+			// so it must not be changed.
+			if ns == nil {
+				ns = NewNamespace()
+			} else {
+				ns = ns.clone()
+			}
+			if err := ns.merge(arg); err != nil {
+				return nil, fmt.Errorf("invalid namespace caveat in macaroon %q: %v", m.Id(), err)
+			}
+			if scopes == nil {
+				scopes = make(namespaceScopes)
+			}
+			scopes[m] = append(scopes[m], namespaceScope{
+				index: i,
+				ctx:   ContextWithNamespace(ctx, ns),
+			})
+		}
+	}
+	return scopes, nil
+}
+
+// context returns the context to use when checking the caveat
+// with the given index in m: the one holding the namespace
+// declared by the namespace caveats before it, or ctx if
+// there are none.
+func (scopes namespaceScopes) context(ctx context.Context, m *Macaroon, i int) context.Context {
+	ms := scopes[m]
+	for j := len(ms) - 1; j >= 0; j-- {
+		if ms[j].index < i {
+			return ms[j].ctx
+		}
+	}
+	return ctx
+}
+
+type namespaceKey struct{}
+
+// ContextWithNamespace returns a context holding the given namespace,
+// used by Checker to resolve the prefixes in caveat conditions.
+func ContextWithNamespace(ctx context.Context, ns *Namespace) context.Context {
+	return context.WithValue(ctx, namespaceKey{}, ns)
+}
+
+// NamespaceFromContext returns the namespace held in
+// the context by ContextWithNamespace.
+func NamespaceFromContext(ctx context.Context) *Namespace {
+	ns, _ := ctx.Value(namespaceKey{}).(*Namespace)
+	return ns
+}
+
+func checkNamespace(ctx context.Context, _, arg string) error {
+	_, err := ParseNamespace(arg)
+	return err
+}
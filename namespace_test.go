@@ -0,0 +1,262 @@
+package macaroon_test
+
+import (
+	"context"
+	"errors"
+
+	gc "gopkg.in/check.v1"
+
+	"github.com/iron-io/macaroon"
+)
+
+type namespaceSuite struct{}
+
+var _ = gc.Suite(&namespaceSuite{})
+
+const (
+	accountsURI = "https://example.com/accounts"
+	billingURI  = "https://example.com/billing"
+)
+
+// namespaceChecker returns a checker with an "account" condition
+// in each of two namespaces. Each records the namespace URI of the
+// condition that was checked.
+func namespaceChecker(checked *[]string) *macaroon.Checker {
+	checker := macaroon.NewChecker()
+	checker.RegisterNS(accountsURI, "acc", "account", func(ctx context.Context, name, arg string) error {
+		*checked = append(*checked, accountsURI+" "+arg)
+		return nil
+	})
+	checker.RegisterNS(billingURI, "bill", "account", func(ctx context.Context, name, arg string) error {
+		*checked = append(*checked, billingURI+" "+arg)
+		return nil
+	})
+	return checker
+}
+
+func (*namespaceSuite) TestNamespace(c *gc.C) {
+	ns := macaroon.NewNamespace()
+	err := ns.Register(accountsURI, "acc")
+	c.Assert(err, gc.IsNil)
+	err = ns.Register(billingURI, "bill")
+	c.Assert(err, gc.IsNil)
+	err = ns.Register(accountsURI, "acc")
+	c.Assert(err, gc.IsNil)
+	c.Assert(ns.String(), gc.Equals, "acc:"+accountsURI+" bill:"+billingURI)
+
+	err = ns.Register(billingURI, "acc")
+	c.Assert(err, gc.ErrorMatches, `prefix "acc" is already used for "https://example.com/accounts"`)
+	err = ns.Register(billingURI, "b")
+	c.Assert(err, gc.ErrorMatches, `namespace "https://example.com/billing" already has prefix "bill"`)
+	err = ns.Register("", "x")
+	c.Assert(err, gc.ErrorMatches, `invalid namespace URI ""`)
+	err = ns.Register("x", "a:b")
+	c.Assert(err, gc.ErrorMatches, `invalid namespace prefix "a:b"`)
+
+	ns1, err := macaroon.ParseNamespace(ns.String())
+	c.Assert(err, gc.IsNil)
+	c.Assert(ns1, gc.DeepEquals, ns)
+	_, err = macaroon.ParseNamespace("foo")
+	c.Assert(err, gc.ErrorMatches, `no colon in namespace field "foo"`)
+
+	cav, err := ns.Condition(billingURI, "account", "123")
+	c.Assert(err, gc.IsNil)
+	c.Assert(cav, gc.Equals, "bill:account 123")
+	_, err = ns.Condition("other", "account", "123")
+	c.Assert(err, gc.ErrorMatches, `no prefix registered for namespace "other"`)
+}
+
+func (*namespaceSuite) TestCheckerUsesMacaroonNamespace(c *gc.C) {
+	var checked []string
+	checker := namespaceChecker(&checked)
+
+	// The macaroon uses its own prefixes, which differ
+	// from those used by the checker.
+	ns := macaroon.NewNamespace()
+	err := ns.Register(billingURI, "acc")
+	c.Assert(err, gc.IsNil)
+	err = ns.Register(accountsURI, "a")
+	c.Assert(err, gc.IsNil)
+
+	rootKey := []byte("secret")
+	m := MustNew(rootKey, "some id", "")
+	for _, cav := range []string{
+		macaroon.NamespaceCaveat(ns),
+		"acc:account 1",
+		"a:account 2",
+	} {
+		err := m.AddFirstPartyCaveat(cav)
+		c.Assert(err, gc.IsNil)
+	}
+	_, err = checker.Verify(context.Background(), rootKey, macaroon.Slice{m}, nil)
+	c.Assert(err, gc.IsNil)
+	c.Assert(checked, gc.DeepEquals, []string{
+		billingURI + " 1",
+		accountsURI + " 2",
+	})
+}
+
+func (*namespaceSuite) TestCheckerNamespaceInDischarge(c *gc.C) {
+	var checked []string
+	checker := namespaceChecker(&checked)
+	ns := macaroon.NewNamespace()
+	err := ns.Register(billingURI, "b")
+	c.Assert(err, gc.IsNil)
+
+	rootKey := []byte("secret")
+	m := MustNew(rootKey, "some id", "")
+	err = m.AddThirdPartyCaveat([]byte("third party key"), "cond", "remote")
+	c.Assert(err, gc.IsNil)
+	dm := MustNew([]byte("third party key"), "cond", "remote")
+	err = dm.AddFirstPartyCaveat(macaroon.NamespaceCaveat(ns))
+	c.Assert(err, gc.IsNil)
+	err = dm.AddFirstPartyCaveat("b:account 3")
+	c.Assert(err, gc.IsNil)
+	s := macaroon.Slice{m, dm}
+	s.Bind()
+	_, err = checker.Verify(context.Background(), rootKey, s, nil)
+	c.Assert(err, gc.IsNil)
+	c.Assert(checked, gc.DeepEquals, []string{billingURI + " 3"})
+}
+
+func (*namespaceSuite) TestCheckerAppendedNamespace(c *gc.C) {
+	var checked []string
+	checker := namespaceChecker(&checked)
+
+	// A namespace caveat appended by the holder of a macaroon
+	// must not change the meaning of the caveats before it.
+	rootKey := []byte("secret")
+	m := MustNew(rootKey, "some id", "")
+	for _, cav := range []string{
+		"acc:account 1",
+		"ns acc:" + billingURI,
+		"acc:account 2",
+	} {
+		err := m.AddFirstPartyCaveat(cav)
+		c.Assert(err, gc.IsNil)
+	}
+	_, err := checker.Verify(context.Background(), rootKey, macaroon.Slice{m}, nil)
+	c.Assert(err, gc.IsNil)
+	c.Assert(checked, gc.DeepEquals, []string{
+		accountsURI + " 1",
+		billingURI + " 2",
+	})
+}
+
+func (*namespaceSuite) TestCheckerNamespaceNotShared(c *gc.C) {
+	var checked []string
+	checker := macaroon.NewChecker()
+	checker.RegisterNS(accountsURI, "acc", "account", func(ctx context.Context, name, arg string) error {
+		checked = append(checked, accountsURI+" "+arg)
+		return nil
+	})
+	checker.RegisterNSDynamic(billingURI, "bill", "account", func(ctx context.Context, name, arg string) error {
+		checked = append(checked, billingURI+" "+arg)
+		return nil
+	})
+
+	// A namespace declared in a discharge macaroon does
+	// not apply to the caveats in the primary macaroon.
+	rootKey := []byte("secret")
+	m := MustNew(rootKey, "some id", "")
+	err := m.AddFirstPartyCaveat("acc:account 1")
+	c.Assert(err, gc.IsNil)
+	err = m.AddThirdPartyCaveat([]byte("third party key"), "cond", "remote")
+	c.Assert(err, gc.IsNil)
+	err = m.AddFirstPartyCaveat("acc:account 2")
+	c.Assert(err, gc.IsNil)
+	dm := MustNew([]byte("third party key"), "cond", "remote")
+	err = dm.AddFirstPartyCaveat("ns acc:" + billingURI)
+	c.Assert(err, gc.IsNil)
+	err = dm.AddFirstPartyCaveat("acc:account 3")
+	c.Assert(err, gc.IsNil)
+	s := macaroon.Slice{m, dm}
+	s.Bind()
+
+	opts := &macaroon.VerifyOptions{
+		Cache: macaroon.NewVerifyCache(10),
+	}
+	_, err = checker.Verify(context.Background(), rootKey, s, opts)
+	c.Assert(err, gc.IsNil)
+	c.Assert(checked, gc.DeepEquals, []string{
+		accountsURI + " 1",
+		billingURI + " 3",
+		accountsURI + " 2",
+	})
+	c.Assert(opts.Cache.Len(), gc.Equals, 1)
+
+	// On a cache hit, the same namespaces are used
+	// to find which conditions are dynamic.
+	checked = nil
+	_, err = checker.Verify(context.Background(), rootKey, s, opts)
+	c.Assert(err, gc.IsNil)
+	c.Assert(checked, gc.DeepEquals, []string{billingURI + " 3"})
+}
+
+func (*namespaceSuite) TestCheckerUnknownNamespace(c *gc.C) {
+	var checked []string
+	checker := namespaceChecker(&checked)
+	ns := macaroon.NewNamespace()
+	err := ns.Register("https://example.com/unknown", "u")
+	c.Assert(err, gc.IsNil)
+
+	rootKey := []byte("secret")
+	tests := []struct {
+		caveats   []string
+		expectErr string
+	}{{
+		caveats:   []string{"x:account 1"},
+		expectErr: `caveat "x:account 1" not satisfied: unknown namespace prefix "x": caveat not recognized`,
+	}, {
+		caveats:   []string{macaroon.NamespaceCaveat(ns), "u:account 1"},
+		expectErr: `caveat "u:account 1" not satisfied: unknown namespace "https://example.com/unknown": caveat not recognized`,
+	}, {
+		caveats:   []string{"acc:other 1"},
+		expectErr: `caveat "acc:other 1" not satisfied: caveat not recognized`,
+	}}
+	for i, test := range tests {
+		c.Logf("test %d", i)
+		m := MustNew(rootKey, "some id", "")
+		for _, cav := range test.caveats {
+			err := m.AddFirstPartyCaveat(cav)
+			c.Assert(err, gc.IsNil)
+		}
+		_, err := checker.Verify(context.Background(), rootKey, macaroon.Slice{m}, nil)
+		c.Assert(err, gc.ErrorMatches, test.expectErr)
+		c.Assert(errors.Is(err, macaroon.ErrCaveatNotRecognized), gc.Equals, true)
+	}
+	c.Assert(checked, gc.HasLen, 0)
+
+	// The checker's own prefixes are used when the
+	// macaroon does not declare them.
+	err = checker.CheckFirstPartyCaveat(context.Background(), "bill:account 4")
+	c.Assert(err, gc.IsNil)
+	c.Assert(checked, gc.DeepEquals, []string{billingURI + " 4"})
+}
+
+func (*namespaceSuite) TestConflictingNamespaceCaveats(c *gc.C) {
+	checker := macaroon.NewChecker()
+	rootKey := []byte("secret")
+	m := MustNew(rootKey, "some id", "")
+	for _, cav := range []string{"ns a:uri1", "ns a:uri2"} {
+		err := m.AddFirstPartyCaveat(cav)
+		c.Assert(err, gc.IsNil)
+	}
+	_, err := checker.Verify(context.Background(), rootKey, macaroon.Slice{m}, nil)
+	c.Assert(err, gc.ErrorMatches, `invalid namespace caveat in macaroon "some id": prefix "a" is already used for "uri1"`)
+}
+
+func (*namespaceSuite) TestRegisterNSPanics(c *gc.C) {
+	checker := macaroon.NewChecker()
+	checker.RegisterNS(accountsURI, "acc", "account", nil)
+	c.Assert(func() {
+		checker.RegisterNS(accountsURI, "acc", "account", nil)
+	}, gc.PanicMatches, `caveat condition "account" is already registered in namespace "https://example.com/accounts"`)
+	c.Assert(func() {
+		checker.RegisterNS(billingURI, "acc", "account", nil)
+	}, gc.PanicMatches, `prefix "acc" is already used for .*`)
+	c.Assert(func() {
+		checker.Register("acc:account", nil)
+	}, gc.PanicMatches, `invalid caveat condition name "acc:account"`)
+	c.Assert(checker.Namespace().String(), gc.Equals, "acc:"+accountsURI)
+}
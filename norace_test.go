@@ -0,0 +1,5 @@
+//go:build !race
+
+package macaroon_test
+
+const raceEnabled = false
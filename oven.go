@@ -0,0 +1,227 @@
+package macaroon
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// Op holds an operation that may be authorized by a macaroon:
+// an action on some entity.
+type Op struct {
+	Entity string
+	Action string
+}
+
+func (op Op) String() string {
+	return op.Entity + ":" + op.Action
+}
+
+// Oven mints macaroons that are scoped to a set of operations,
+// and reports which operations a set of macaroons authorizes.
+//
+// The operations are encoded in the identifier of each minted
+// macaroon, following a random nonce so that no two macaroons
+// have the same identifier.
+type Oven struct {
+	// Location holds the location of the minted macaroons.
+	Location string
+
+	// RootKey holds the root key used to mint
+	// and verify the macaroons.
+	RootKey []byte
+
+	// Rand holds the source of randomness used to create
+	// macaroon identifiers. If it is nil, rand.Reader is used.
+	Rand io.Reader
+}
+
+// opsNonceLen holds the number of random bytes
+// at the start of each oven macaroon identifier.
+const opsNonceLen = 16
+
+// NewMacaroon returns a macaroon that authorizes the given
+// operations, with the given first party caveats added.
+func (o *Oven) NewMacaroon(ops []Op, caveats ...string) (*Macaroon, error) {
+	if len(ops) == 0 {
+		return nil, fmt.Errorf("cannot mint macaroon with no operations")
+	}
+	r := o.Rand
+	if r == nil {
+		r = rand.Reader
+	}
+	var nonce [opsNonceLen]byte
+	if _, err := io.ReadFull(r, nonce[:]); err != nil {
+		return nil, fmt.Errorf("cannot generate random bytes: %v", err)
+	}
+	m, err := New(o.RootKey, hex.EncodeToString(nonce[:])+" "+encodeOps(ops), o.Location)
+	if err != nil {
+		return nil, err
+	}
+	for _, cav := range caveats {
+		if err := m.AddFirstPartyCaveat(cav); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// encodeOps returns a compact encoding of the given operations.
+// Actions on the same entity are grouped together, so the result
+// has the form "entity1:action1,action2 entity2:action3". Entities
+// and actions are query-escaped and sorted, and duplicates are removed.
+func encodeOps(ops []Op) string {
+	ops = canonicalOps(ops)
+	var buf strings.Builder
+	for i, op := range ops {
+		if i > 0 && op.Entity == ops[i-1].Entity {
+			buf.WriteByte(',')
+		} else {
+			if i > 0 {
+				buf.WriteByte(' ')
+			}
+			buf.WriteString(url.QueryEscape(op.Entity))
+			buf.WriteByte(':')
+		}
+		buf.WriteString(url.QueryEscape(op.Action))
+	}
+	return buf.String()
+}
+
+// decodeOps decodes operations encoded by encodeOps.
+func decodeOps(s string) ([]Op, error) {
+	var ops []Op
+	for _, group := range strings.Split(s, " ") {
+		i := strings.Index(group, ":")
+		if i < 0 {
+			return nil, fmt.Errorf("no entity found in %q", group)
+		}
+		entity, err := url.QueryUnescape(group[0:i])
+		if err != nil {
+			return nil, fmt.Errorf("bad entity %q: %v", group[0:i], err)
+		}
+		for _, a := range strings.Split(group[i+1:], ",") {
+			action, err := url.QueryUnescape(a)
+			if err != nil {
+				return nil, fmt.Errorf("bad action %q: %v", a, err)
+			}
+			ops = append(ops, Op{
+				Entity: entity,
+				Action: action,
+			})
+		}
+	}
+	return ops, nil
+}
+
+// canonicalOps returns the given operations sorted
+// and with duplicates removed.
+func canonicalOps(ops []Op) []Op {
+	ops = append([]Op(nil), ops...)
+	sort.Slice(ops, func(i, j int) bool {
+		if ops[i].Entity != ops[j].Entity {
+			return ops[i].Entity < ops[j].Entity
+		}
+		return ops[i].Action < ops[j].Action
+	})
+	j := 0
+	for i, op := range ops {
+		if i > 0 && op == ops[i-1] {
+			continue
+		}
+		ops[j] = op
+		j++
+	}
+	return ops[0:j]
+}
+
+// MacaroonOps returns the operations encoded in the identifier
+// of a macaroon minted by an Oven. Note that the operations are not
+// authorized unless the macaroon is successfully verified.
+func MacaroonOps(m *Macaroon) ([]Op, error) {
+	id := m.Id()
+	i := strings.Index(id, " ")
+	if i != 2*opsNonceLen {
+		return nil, fmt.Errorf("macaroon %q was not minted by an oven", id)
+	}
+	return decodeOps(id[i+1:])
+}
+
+// Authorization holds the result of Oven.Authorize. Each
+// requested operation is in exactly one of Allowed,
+// NeedDischarge and Denied.
+type Authorization struct {
+	// Allowed holds the operations that are authorized.
+	Allowed []Op
+
+	// NeedDischarge holds the operations that would be
+	// authorized if discharges were acquired for all
+	// the third party caveats in some macaroon.
+	NeedDischarge []Op
+
+	// Denied holds the operations that are not authorized.
+	Denied []Op
+
+	// DischargeRequired holds the errors from macaroons that
+	// lacked discharges, with one entry for each macaroon
+	// that covers an operation in NeedDischarge.
+	DischargeRequired []*DischargeRequiredError
+}
+
+// Authorize reports which of the given operations are authorized
+// by the given macaroons. Each element of slices should hold a
+// primary macaroon minted by the oven followed by its discharges;
+// elements that cannot be verified by the oven are ignored.
+// The check function is used to check first party caveats as for
+// Macaroon.Verify.
+func (o *Oven) Authorize(slices []Slice, check func(caveat string) error, ops ...Op) *Authorization {
+	allowed := make(map[Op]bool)
+	needDischarge := make(map[Op]*DischargeRequiredError)
+	for _, s := range slices {
+		if len(s) == 0 {
+			continue
+		}
+		mops, err := MacaroonOps(s[0])
+		if err != nil {
+			continue
+		}
+		err = s[0].Verify(o.RootKey, check, s[1:])
+		var derr *DischargeRequiredError
+		switch {
+		case err == nil:
+			for _, op := range mops {
+				allowed[op] = true
+			}
+		case errors.As(err, &derr):
+			for _, op := range mops {
+				if needDischarge[op] == nil {
+					needDischarge[op] = derr
+				}
+			}
+		}
+	}
+	var a Authorization
+	seen := make(map[*DischargeRequiredError]bool)
+	for _, op := range ops {
+		if allowed[op] {
+			a.Allowed = append(a.Allowed, op)
+			continue
+		}
+		derr := needDischarge[op]
+		if derr == nil {
+			a.Denied = append(a.Denied, op)
+			continue
+		}
+		a.NeedDischarge = append(a.NeedDischarge, op)
+		if !seen[derr] {
+			seen[derr] = true
+			a.DischargeRequired = append(a.DischargeRequired, derr)
+		}
+	}
+	return &a
+}
@@ -0,0 +1,156 @@
+package macaroon_test
+
+import (
+	"fmt"
+
+	gc "gopkg.in/check.v1"
+
+	"github.com/iron-io/macaroon"
+)
+
+type ovenSuite struct{}
+
+var _ = gc.Suite(&ovenSuite{})
+
+var (
+	readFile   = macaroon.Op{Entity: "file /a b", Action: "read"}
+	writeFile  = macaroon.Op{Entity: "file /a b", Action: "write"}
+	readUser   = macaroon.Op{Entity: "user:bob", Action: "read"}
+	deleteUser = macaroon.Op{Entity: "user:bob", Action: "delete"}
+)
+
+func newOven() *macaroon.Oven {
+	return &macaroon.Oven{
+		Location: "somewhere",
+		RootKey:  []byte("oven root key"),
+	}
+}
+
+func allow(conds ...string) func(string) error {
+	return func(cond string) error {
+		for _, c := range conds {
+			if c == cond {
+				return nil
+			}
+		}
+		return fmt.Errorf("condition %q not met", cond)
+	}
+}
+
+func (*ovenSuite) TestMacaroonOps(c *gc.C) {
+	o := newOven()
+	m, err := o.NewMacaroon([]macaroon.Op{writeFile, readUser, readFile, writeFile})
+	c.Assert(err, gc.IsNil)
+	c.Assert(m.Location(), gc.Equals, "somewhere")
+	c.Assert(m.Id(), gc.Matches, `[0-9a-f]{32} file\+%2Fa\+b:read,write user%3Abob:read`)
+	ops, err := macaroon.MacaroonOps(m)
+	c.Assert(err, gc.IsNil)
+	c.Assert(ops, gc.DeepEquals, []macaroon.Op{readFile, writeFile, readUser})
+
+	m1, err := o.NewMacaroon([]macaroon.Op{writeFile, readUser, readFile})
+	c.Assert(err, gc.IsNil)
+	c.Assert(m1.Id(), gc.Not(gc.Equals), m.Id())
+
+	_, err = macaroon.MacaroonOps(MustNew([]byte("key"), "other id", ""))
+	c.Assert(err, gc.ErrorMatches, `macaroon "other id" was not minted by an oven`)
+
+	_, err = o.NewMacaroon(nil)
+	c.Assert(err, gc.ErrorMatches, `cannot mint macaroon with no operations`)
+}
+
+func (*ovenSuite) TestNewMacaroonWithRand(c *gc.C) {
+	o := newOven()
+	o.Rand = zeroReader{}
+	m, err := o.NewMacaroon([]macaroon.Op{readFile}, "time-before 2030")
+	c.Assert(err, gc.IsNil)
+	c.Assert(m.Id(), gc.Equals, "00000000000000000000000000000000 file+%2Fa+b:read")
+	c.Assert(m.Caveats(), gc.DeepEquals, []macaroon.Caveat{{Id: "time-before 2030"}})
+
+	o.Rand = &macaroon.ErrorReader{}
+	_, err = o.NewMacaroon([]macaroon.Op{readFile})
+	c.Assert(err, gc.ErrorMatches, `cannot generate random bytes: fail`)
+}
+
+func (*ovenSuite) TestAuthorize(c *gc.C) {
+	o := newOven()
+	fileMacaroon, err := o.NewMacaroon([]macaroon.Op{readFile, writeFile}, "ok")
+	c.Assert(err, gc.IsNil)
+
+	userMacaroon, err := o.NewMacaroon([]macaroon.Op{readUser, deleteUser})
+	c.Assert(err, gc.IsNil)
+	err = userMacaroon.AddThirdPartyCaveat([]byte("third party key"), "is-admin", "admin-service")
+	c.Assert(err, gc.IsNil)
+
+	// A macaroon minted with a different root key grants nothing.
+	otherOven := newOven()
+	otherOven.RootKey = []byte("other key")
+	forged, err := otherOven.NewMacaroon([]macaroon.Op{deleteUser})
+	c.Assert(err, gc.IsNil)
+
+	slices := []macaroon.Slice{{fileMacaroon}, {userMacaroon}, {forged}, {MustNew(o.RootKey, "not an oven macaroon", "")}}
+	a := o.Authorize(slices, allow("ok"), readFile, readUser, deleteUser)
+	c.Assert(a.Allowed, gc.DeepEquals, []macaroon.Op{readFile})
+	c.Assert(a.NeedDischarge, gc.DeepEquals, []macaroon.Op{readUser, deleteUser})
+	c.Assert(a.Denied, gc.HasLen, 0)
+	c.Assert(a.DischargeRequired, gc.HasLen, 1)
+	c.Assert(a.DischargeRequired[0].CaveatId, gc.Equals, "is-admin")
+	c.Assert(a.DischargeRequired[0].Location, gc.Equals, "admin-service")
+
+	// With the discharge, all operations are allowed.
+	dm := MustNew([]byte("third party key"), "is-admin", "admin-service")
+	dm.Bind(userMacaroon.Signature())
+	slices[1] = macaroon.Slice{userMacaroon, dm}
+	a = o.Authorize(slices, allow("ok"), readFile, readUser, deleteUser)
+	c.Assert(a.Allowed, gc.DeepEquals, []macaroon.Op{readFile, readUser, deleteUser})
+	c.Assert(a.NeedDischarge, gc.HasLen, 0)
+	c.Assert(a.Denied, gc.HasLen, 0)
+
+	// When a first party caveat fails, the operations are denied.
+	a = o.Authorize(slices, allow(), readFile, readUser)
+	c.Assert(a.Allowed, gc.DeepEquals, []macaroon.Op{readUser})
+	c.Assert(a.Denied, gc.DeepEquals, []macaroon.Op{readFile})
+
+	// Operations that no macaroon mentions are denied.
+	a = o.Authorize(slices, allow("ok"), macaroon.Op{Entity: "other", Action: "read"})
+	c.Assert(a.Allowed, gc.HasLen, 0)
+	c.Assert(a.Denied, gc.DeepEquals, []macaroon.Op{{Entity: "other", Action: "read"}})
+}
+
+func (*ovenSuite) TestAuthorizeChecksAfterMissingDischarge(c *gc.C) {
+	o := newOven()
+	m0, err := o.NewMacaroon([]macaroon.Op{readUser})
+	c.Assert(err, gc.IsNil)
+	err = m0.AddThirdPartyCaveat([]byte("third party key"), "is-admin", "admin-service")
+	c.Assert(err, gc.IsNil)
+	m1 := m0.Clone()
+	err = m1.AddFirstPartyCaveat("expired")
+	c.Assert(err, gc.IsNil)
+
+	// A first party caveat after the undischarged caveat
+	// is still checked.
+	a := o.Authorize([]macaroon.Slice{{m1}}, allow(), readUser)
+	c.Assert(a.NeedDischarge, gc.HasLen, 0)
+	c.Assert(a.Denied, gc.DeepEquals, []macaroon.Op{readUser})
+	a = o.Authorize([]macaroon.Slice{{m1}}, allow("expired"), readUser)
+	c.Assert(a.NeedDischarge, gc.DeepEquals, []macaroon.Op{readUser})
+	c.Assert(a.Denied, gc.HasLen, 0)
+
+	// Truncating the macaroon to drop that caveat,
+	// keeping its signature, is detected.
+	data0, data1 := mustMarshalBinary(m0), mustMarshalBinary(m1)
+	// The signature is held in the last packet, which
+	// has a three byte header.
+	sigPacketLen := 3 + len(m1.Signature())
+	truncatedData := append(data0[0:len(data0)-sigPacketLen:len(data0)-sigPacketLen], data1[len(data1)-sigPacketLen:]...)
+	var truncated macaroon.Macaroon
+	err = truncated.UnmarshalBinary(truncatedData)
+	c.Assert(err, gc.IsNil)
+	c.Assert(truncated.Caveats(), gc.HasLen, 1)
+	c.Assert(truncated.Signature(), gc.DeepEquals, m1.Signature())
+
+	a = o.Authorize([]macaroon.Slice{{&truncated}}, allow(), readUser)
+	c.Assert(a.NeedDischarge, gc.HasLen, 0)
+	c.Assert(a.Denied, gc.DeepEquals, []macaroon.Op{readUser})
+	err = truncated.Verify(o.RootKey, allow(), nil)
+	c.Assert(err, gc.ErrorMatches, `signature mismatch after caveat verification`)
+}
@@ -3,6 +3,7 @@ package macaroon
 import (
 	"encoding/binary"
 	"fmt"
+	"unsafe"
 )
 
 // The macaroon binary encoding is made from a sequence
@@ -34,6 +35,16 @@ func (p packet) len() int {
 
 const headerLen = 3
 
+// shift returns the packet moved by the given number
+// of bytes within the macaroon data. The zero packet
+// is returned unchanged.
+func (p packet) shift(offset int32) packet {
+	if p.totalLen != 0 {
+		p.start += offset
+	}
+	return p
+}
+
 // dataBytes returns the data payload of the packet.
 func (m *Macaroon) dataBytes(p packet) []byte {
 	if p.totalLen == 0 {
@@ -46,6 +57,20 @@ func (m *Macaroon) dataStr(p packet) string {
 	return string(m.dataBytes(p))
 }
 
+// dataStrNoCopy is like dataStr except that the returned string
+// refers to the macaroon's data rather than a copy of it. This is
+// safe because the bytes within a macaroon's data are never changed
+// once written: the data is only ever appended to, and Clone and
+// UnmarshalBinary ensure that appending never overwrites data that
+// is shared with another macaroon.
+func (m *Macaroon) dataStrNoCopy(p packet) string {
+	data := m.dataBytes(p)
+	if len(data) == 0 {
+		return ""
+	}
+	return unsafe.String(&data[0], len(data))
+}
+
 // packetBytes returns the entire packet.
 func (m *Macaroon) packetBytes(p packet) []byte {
 	return m.data[p.start : p.start+int32(p.totalLen)]
@@ -63,14 +88,16 @@ func (m *Macaroon) fieldNum(p packet) field {
 // index into m.data.
 func (m *Macaroon) parsePacket(start int) (packet, error) {
 	data := m.data[start:]
-	if len(data) < 6 {
+	if len(data) < headerLen {
 		return packet{}, fmt.Errorf("packet too short")
 	}
 	plen := parseSize(data)
+	if plen < headerLen {
+		return packet{}, fmt.Errorf("packet size too small")
+	}
 	if plen > len(data) {
 		return packet{}, fmt.Errorf("packet size too big")
 	}
-	data = data[2:plen]
 	return packet{
 		start:    int32(start),
 		totalLen: uint16(plen),
@@ -0,0 +1,245 @@
+package macaroon
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// RevocationKind identifies the kind of value held in a deny list.
+type RevocationKind int
+
+const (
+	// RevokedMacaroonId denies any primary macaroon
+	// with the given id.
+	RevokedMacaroonId RevocationKind = iota + 1
+
+	// RevokedDischargeId denies any discharge macaroon
+	// with the given id.
+	RevokedDischargeId
+
+	// RevokedCondition denies any macaroon containing
+	// a first party caveat with the given condition.
+	RevokedCondition
+)
+
+var revocationKindStrings = [...]string{
+	RevokedMacaroonId:  "macaroon-id",
+	RevokedDischargeId: "discharge-id",
+	RevokedCondition:   "condition",
+}
+
+func (k RevocationKind) String() string {
+	if !k.valid() {
+		return fmt.Sprintf("RevocationKind(%d)", int(k))
+	}
+	return revocationKindStrings[k]
+}
+
+func (k RevocationKind) valid() bool {
+	return k > 0 && int(k) < len(revocationKindStrings)
+}
+
+// checkRevocation checks that a value of the given kind
+// can be added to a deny list. No macaroon id or caveat
+// condition can be longer than a packet.
+func checkRevocation(kind RevocationKind, value string) error {
+	if !kind.valid() {
+		return fmt.Errorf("invalid revocation kind %v", kind)
+	}
+	if len(value) > maxPacketLen {
+		return fmt.Errorf("revoked value too long (%d bytes)", len(value))
+	}
+	return nil
+}
+
+func parseRevocationKind(s string) (RevocationKind, error) {
+	for k, ks := range revocationKindStrings {
+		if k > 0 && ks == s {
+			return RevocationKind(k), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown revocation kind %q", s)
+}
+
+// RevocationChecker is used by VerifyWithOptions to find out
+// whether any macaroon in the chain being verified has been revoked.
+type RevocationChecker interface {
+	// IsRevoked reports whether the given value of the
+	// given kind has been revoked.
+	IsRevoked(kind RevocationKind, value string) (bool, error)
+}
+
+// RevocationStore is a RevocationChecker that
+// can also record revocations.
+type RevocationStore interface {
+	RevocationChecker
+
+	// Revoke adds the given value of the given kind to the
+	// deny list. It returns an error if the kind is invalid
+	// or the value is too long to be a macaroon id or
+	// caveat condition.
+	Revoke(kind RevocationKind, value string) error
+}
+
+// RevokedError is the error returned by VerifyWithOptions when
+// a macaroon in the chain matches the deny list.
+type RevokedError struct {
+	Kind  RevocationKind
+	Value string
+}
+
+func (e *RevokedError) Error() string {
+	return fmt.Sprintf("%v %q has been revoked", e.Kind, e.Value)
+}
+
+// revocationKey is used as a key in the revocation maps.
+type revocationKey struct {
+	kind  RevocationKind
+	value string
+}
+
+// MemRevocationStore is a RevocationStore that holds
+// its deny list in memory. It is safe to use concurrently.
+type MemRevocationStore struct {
+	mu      sync.RWMutex
+	revoked map[revocationKey]bool
+}
+
+// NewMemRevocationStore returns a new, empty, in-memory
+// revocation store.
+func NewMemRevocationStore() *MemRevocationStore {
+	return &MemRevocationStore{
+		revoked: make(map[revocationKey]bool),
+	}
+}
+
+// IsRevoked implements RevocationChecker.IsRevoked.
+func (s *MemRevocationStore) IsRevoked(kind RevocationKind, value string) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.revoked[revocationKey{kind, value}], nil
+}
+
+// Revoke implements RevocationStore.Revoke.
+func (s *MemRevocationStore) Revoke(kind RevocationKind, value string) error {
+	if err := checkRevocation(kind, value); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.revoked[revocationKey{kind, value}] = true
+	return nil
+}
+
+// FileRevocationStore is a RevocationStore that records its
+// deny list in a file. Each line of the file holds a revocation
+// kind (as returned by RevocationKind.String) followed by a space
+// and the revoked value as a Go quoted string.
+//
+// The file is read when the store is opened; revocations made
+// by other processes after that are not seen until the store
+// is opened again. It is safe to use concurrently.
+type FileRevocationStore struct {
+	mem  *MemRevocationStore
+	mu   sync.Mutex
+	file *os.File
+}
+
+// OpenFileRevocationStore opens the revocation store held in the
+// file with the given path, creating the file if necessary.
+func OpenFileRevocationStore(path string) (*FileRevocationStore, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open revocation store: %v", err)
+	}
+	s := &FileRevocationStore{
+		mem:  NewMemRevocationStore(),
+		file: f,
+	}
+	scanner := bufio.NewScanner(f)
+	// Quoting can expand a value to several times
+	// its size, so allow for lines longer than the default.
+	scanner.Buffer(nil, 4*maxPacketLen+64)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := scanner.Text()
+		if line == "" {
+			continue
+		}
+		kind, value, err := parseRevocationLine(line)
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("cannot parse %s:%d: %v", path, lineNum, err)
+		}
+		s.mem.revoked[revocationKey{kind, value}] = true
+	}
+	if err := scanner.Err(); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("cannot read revocation store: %v", err)
+	}
+	return s, nil
+}
+
+func parseRevocationLine(line string) (RevocationKind, string, error) {
+	i := strings.Index(line, " ")
+	if i < 0 {
+		return 0, "", fmt.Errorf("missing revoked value")
+	}
+	kind, err := parseRevocationKind(line[0:i])
+	if err != nil {
+		return 0, "", err
+	}
+	value, err := strconv.Unquote(line[i+1:])
+	if err != nil {
+		return 0, "", fmt.Errorf("cannot unquote revoked value: %v", err)
+	}
+	return kind, value, nil
+}
+
+// IsRevoked implements RevocationChecker.IsRevoked.
+func (s *FileRevocationStore) IsRevoked(kind RevocationKind, value string) (bool, error) {
+	return s.mem.IsRevoked(kind, value)
+}
+
+// Revoke implements RevocationStore.Revoke. The revocation
+// is written to the file before Revoke returns.
+func (s *FileRevocationStore) Revoke(kind RevocationKind, value string) error {
+	if err := checkRevocation(kind, value); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := fmt.Fprintf(s.file, "%v %s\n", kind, strconv.Quote(value)); err != nil {
+		return fmt.Errorf("cannot write revocation: %v", err)
+	}
+	if err := s.file.Sync(); err != nil {
+		return fmt.Errorf("cannot sync revocation store: %v", err)
+	}
+	return s.mem.Revoke(kind, value)
+}
+
+// Close closes the underlying file.
+func (s *FileRevocationStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.file.Close()
+}
+
+// checkRevoked returns a *RevokedError if the given value of the
+// given kind has been revoked according to r.
+func checkRevoked(r RevocationChecker, kind RevocationKind, value string) error {
+	revoked, err := r.IsRevoked(kind, value)
+	if err != nil {
+		return fmt.Errorf("cannot check revocation: %v", err)
+	}
+	if revoked {
+		return &RevokedError{
+			Kind:  kind,
+			Value: value,
+		}
+	}
+	return nil
+}
@@ -0,0 +1,186 @@
+package macaroon_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	gc "gopkg.in/check.v1"
+
+	"github.com/iron-io/macaroon"
+)
+
+type revokeSuite struct{}
+
+var _ = gc.Suite(&revokeSuite{})
+
+var revocationTests = []struct {
+	about       string
+	kind        macaroon.RevocationKind
+	value       string
+	expectErr   string
+	expectValue string
+}{{
+	about: "nothing revoked",
+}, {
+	about:     "primary macaroon id",
+	kind:      macaroon.RevokedMacaroonId,
+	value:     "root-id",
+	expectErr: `macaroon-id "root-id" has been revoked`,
+}, {
+	about:     "discharge macaroon id",
+	kind:      macaroon.RevokedDischargeId,
+	value:     "ben-is-great",
+	expectErr: `discharge-id "ben-is-great" has been revoked`,
+}, {
+	about: "primary id revoked as discharge id",
+	kind:  macaroon.RevokedDischargeId,
+	value: "root-id",
+}, {
+	about: "discharge id revoked as primary id",
+	kind:  macaroon.RevokedMacaroonId,
+	value: "bob-is-great",
+}, {
+	about:     "condition in primary",
+	kind:      macaroon.RevokedCondition,
+	value:     "wonderful",
+	expectErr: `condition "wonderful" has been revoked`,
+}, {
+	about:     "condition in discharge",
+	kind:      macaroon.RevokedCondition,
+	value:     "high-fiving",
+	expectErr: `condition "high-fiving" has been revoked`,
+}, {
+	about: "unknown condition",
+	kind:  macaroon.RevokedCondition,
+	value: "bob-is-great",
+}}
+
+func (*revokeSuite) TestVerifyWithRevocation(c *gc.C) {
+	rootKey, primary, discharges := makeMacaroons(recursiveThirdPartyCaveatMacaroons)
+	checked := make(map[string]bool)
+	check := func(cond string) error {
+		checked[cond] = true
+		return nil
+	}
+	for i, test := range revocationTests {
+		c.Logf("test %d: %s", i, test.about)
+		store := macaroon.NewMemRevocationStore()
+		if test.kind != 0 {
+			err := store.Revoke(test.kind, test.value)
+			c.Assert(err, gc.IsNil)
+		}
+		checked = make(map[string]bool)
+		err := primary.VerifyWithOptions(rootKey, check, discharges, &macaroon.VerifyOptions{
+			Revocation: store,
+		})
+		if test.expectErr == "" {
+			c.Assert(err, gc.IsNil)
+			continue
+		}
+		c.Assert(err, gc.ErrorMatches, test.expectErr)
+		var rerr *macaroon.RevokedError
+		c.Assert(errors.As(err, &rerr), gc.Equals, true)
+		c.Assert(rerr.Kind, gc.Equals, test.kind)
+		c.Assert(rerr.Value, gc.Equals, test.value)
+		if test.kind == macaroon.RevokedCondition {
+			// The revoked condition must not reach the check function.
+			c.Assert(checked[test.value], gc.Equals, false)
+		}
+	}
+}
+
+type errorRevocationChecker struct{}
+
+func (errorRevocationChecker) IsRevoked(macaroon.RevocationKind, string) (bool, error) {
+	return false, errors.New("store unavailable")
+}
+
+func (*revokeSuite) TestVerifyWithRevocationError(c *gc.C) {
+	rootKey := []byte("secret")
+	m := MustNew(rootKey, "some id", "a location")
+	err := m.VerifyWithOptions(rootKey, never, nil, &macaroon.VerifyOptions{
+		Revocation: errorRevocationChecker{},
+	})
+	c.Assert(err, gc.ErrorMatches, `cannot check revocation: store unavailable`)
+}
+
+func (*revokeSuite) TestFileRevocationStore(c *gc.C) {
+	path := filepath.Join(c.MkDir(), "revoked")
+	store, err := macaroon.OpenFileRevocationStore(path)
+	c.Assert(err, gc.IsNil)
+
+	revoked, err := store.IsRevoked(macaroon.RevokedMacaroonId, "some id")
+	c.Assert(err, gc.IsNil)
+	c.Assert(revoked, gc.Equals, false)
+
+	err = store.Revoke(macaroon.RevokedMacaroonId, "some id")
+	c.Assert(err, gc.IsNil)
+	err = store.Revoke(macaroon.RevokedCondition, "multi\nline \"condition\"")
+	c.Assert(err, gc.IsNil)
+	err = store.Revoke(macaroon.RevocationKind(99), "x")
+	c.Assert(err, gc.ErrorMatches, `invalid revocation kind RevocationKind\(99\)`)
+	// A value that no macaroon could hold is rejected rather
+	// than written to the file, where it would prevent the
+	// store from being opened again.
+	err = store.Revoke(macaroon.RevokedCondition, strings.Repeat("\x00", macaroon.MaxPacketLen+1))
+	c.Assert(err, gc.ErrorMatches, `revoked value too long \(65536 bytes\)`)
+	err = store.Revoke(macaroon.RevokedCondition, strings.Repeat("\x00", macaroon.MaxPacketLen))
+	c.Assert(err, gc.IsNil)
+
+	revoked, err = store.IsRevoked(macaroon.RevokedMacaroonId, "some id")
+	c.Assert(err, gc.IsNil)
+	c.Assert(revoked, gc.Equals, true)
+	err = store.Close()
+	c.Assert(err, gc.IsNil)
+
+	data, err := ioutil.ReadFile(path)
+	c.Assert(err, gc.IsNil)
+	c.Assert(string(data), gc.Equals, `macaroon-id "some id"
+condition "multi\nline \"condition\""
+condition "`+strings.Repeat(`\x00`, macaroon.MaxPacketLen)+`"
+`)
+
+	// Reopening the store sees the previous revocations.
+	store, err = macaroon.OpenFileRevocationStore(path)
+	c.Assert(err, gc.IsNil)
+	defer store.Close()
+	revoked, err = store.IsRevoked(macaroon.RevokedCondition, "multi\nline \"condition\"")
+	c.Assert(err, gc.IsNil)
+	c.Assert(revoked, gc.Equals, true)
+	revoked, err = store.IsRevoked(macaroon.RevokedDischargeId, "some id")
+	c.Assert(err, gc.IsNil)
+	c.Assert(revoked, gc.Equals, false)
+
+	rootKey := []byte("secret")
+	m := MustNew(rootKey, "some id", "a location")
+	err = m.VerifyWithOptions(rootKey, never, nil, &macaroon.VerifyOptions{
+		Revocation: store,
+	})
+	c.Assert(err, gc.ErrorMatches, `macaroon-id "some id" has been revoked`)
+}
+
+func (*revokeSuite) TestMemRevocationStoreInvalid(c *gc.C) {
+	store := macaroon.NewMemRevocationStore()
+	err := store.Revoke(macaroon.RevocationKind(0), "x")
+	c.Assert(err, gc.ErrorMatches, `invalid revocation kind RevocationKind\(0\)`)
+	err = store.Revoke(macaroon.RevokedMacaroonId, strings.Repeat("x", macaroon.MaxPacketLen+1))
+	c.Assert(err, gc.ErrorMatches, `revoked value too long \(65536 bytes\)`)
+	revoked, err := store.IsRevoked(macaroon.RevocationKind(0), "x")
+	c.Assert(err, gc.IsNil)
+	c.Assert(revoked, gc.Equals, false)
+}
+
+func (*revokeSuite) TestOpenFileRevocationStoreBadData(c *gc.C) {
+	path := filepath.Join(c.MkDir(), "revoked")
+	err := ioutil.WriteFile(path, []byte("macaroon-id \"ok\"\nbogus \"x\"\n"), 0600)
+	c.Assert(err, gc.IsNil)
+	_, err = macaroon.OpenFileRevocationStore(path)
+	c.Assert(err, gc.ErrorMatches, `cannot parse .*revoked:2: unknown revocation kind "bogus"`)
+
+	err = ioutil.WriteFile(path, []byte("condition unquoted\n"), 0600)
+	c.Assert(err, gc.IsNil)
+	_, err = macaroon.OpenFileRevocationStore(path)
+	c.Assert(err, gc.ErrorMatches, `cannot parse .*revoked:1: cannot unquote revoked value: invalid syntax`)
+}
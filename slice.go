@@ -0,0 +1,102 @@
+package macaroon
+
+import (
+	"fmt"
+)
+
+// Primary returns the primary macaroon in s,
+// or nil if s is empty.
+func (s Slice) Primary() *Macaroon {
+	if len(s) == 0 {
+		return nil
+	}
+	return s[0]
+}
+
+// Bind binds all the discharge macaroons in s to the primary
+// macaroon. It should be called once only, after all the
+// discharge macaroons have been acquired.
+func (s Slice) Bind() {
+	if len(s) == 0 {
+		return
+	}
+	sig := s[0].sig
+	for _, dm := range s[1:] {
+		dm.Bind(sig)
+	}
+}
+
+// Verify verifies the primary macaroon in s using the rest of
+// the macaroons as discharges. The discharges must already have
+// been bound. See Macaroon.Verify.
+func (s Slice) Verify(rootKey []byte, check func(caveat string) error) error {
+	if len(s) == 0 {
+		return fmt.Errorf("no macaroons in slice")
+	}
+	return s[0].Verify(rootKey, check, s[1:])
+}
+
+// Prune returns a Slice holding the primary macaroon in s followed
+// by the discharge macaroons that are needed to discharge the
+// third party caveats in the primary macaroon, and in those
+// discharges in turn. If there is more than one candidate discharge
+// for a caveat, only the first is kept. Discharges are kept in the
+// same order as in s; s itself is not changed.
+func (s Slice) Prune() Slice {
+	if len(s) == 0 {
+		return nil
+	}
+	needed := make([]bool, len(s))
+	needed[0] = true
+	// We don't need to worry about cycles because each
+	// discharge is visited at most once.
+	queue := []*Macaroon{s[0]}
+	for len(queue) > 0 {
+		m := queue[0]
+		queue = queue[1:]
+		for _, cav := range m.caveats {
+			if !cav.isThirdParty() {
+				continue
+			}
+			cavId := m.dataStr(cav.caveatId)
+			for i, dm := range s[1:] {
+				if dm.Id() == cavId {
+					if !needed[i+1] {
+						needed[i+1] = true
+						queue = append(queue, dm)
+					}
+					break
+				}
+			}
+		}
+	}
+	s1 := make(Slice, 0, len(s))
+	for i, m := range s {
+		if needed[i] {
+			s1 = append(s1, m)
+		}
+	}
+	return s1
+}
+
+// Restrict returns a new Slice holding a copy of the primary macaroon
+// in s restricted with the given conditions (see Macaroon.Restrict),
+// followed by copies of the discharge macaroons bound to it. The
+// discharge macaroons in s must not have been bound already.
+// The macaroons in s are not changed.
+func (s Slice) Restrict(conditions ...string) (Slice, error) {
+	if len(s) == 0 {
+		return nil, fmt.Errorf("no macaroons in slice")
+	}
+	primary, err := s[0].Restrict(conditions...)
+	if err != nil {
+		return nil, err
+	}
+	s1 := make(Slice, len(s))
+	s1[0] = primary
+	for i, dm := range s[1:] {
+		s1[i+1] = dm.deepCopy()
+	}
+	s1.Bind()
+	return s1, nil
+}
@@ -0,0 +1,147 @@
+package macaroon_test
+
+import (
+	gc "gopkg.in/check.v1"
+
+	"github.com/iron-io/macaroon"
+)
+
+type sliceSuite struct{}
+
+var _ = gc.Suite(&sliceSuite{})
+
+func (*sliceSuite) TestRestrict(c *gc.C) {
+	rootKey := []byte("secret")
+	m0 := MustNew(rootKey, "some id", "a location")
+	err := m0.AddFirstPartyCaveat("a caveat")
+	c.Assert(err, gc.IsNil)
+	orig, err := m0.MarshalBinary()
+	c.Assert(err, gc.IsNil)
+
+	m1, err := m0.Restrict("restricted", "more restricted")
+	c.Assert(err, gc.IsNil)
+	m2, err := m0.Restrict("other")
+	c.Assert(err, gc.IsNil)
+
+	// The original is untouched, even though two
+	// restricted copies have been made from it.
+	data, err := m0.MarshalBinary()
+	c.Assert(err, gc.IsNil)
+	c.Assert(data, gc.DeepEquals, orig)
+	c.Assert(m1.Caveats(), gc.DeepEquals, []macaroon.Caveat{{Id: "a caveat"}, {Id: "restricted"}, {Id: "more restricted"}})
+	c.Assert(m2.Caveats(), gc.DeepEquals, []macaroon.Caveat{{Id: "a caveat"}, {Id: "other"}})
+
+	// Adding caveats to the copy does not affect the original.
+	err = m1.AddFirstPartyCaveat("yet more")
+	c.Assert(err, gc.IsNil)
+	data, err = m0.MarshalBinary()
+	c.Assert(err, gc.IsNil)
+	c.Assert(data, gc.DeepEquals, orig)
+
+	err = m2.Verify(rootKey, allow("a caveat", "other"), nil)
+	c.Assert(err, gc.IsNil)
+	err = m2.Verify(rootKey, allow("a caveat"), nil)
+	c.Assert(err, gc.ErrorMatches, `condition "other" not met`)
+}
+
+func (*sliceSuite) TestRestrictDischarge(c *gc.C) {
+	rootKey := []byte("secret")
+	m := MustNew(rootKey, "some id", "a location")
+	err := m.AddThirdPartyCaveat([]byte("shared root key"), "3rd party caveat", "remote.com")
+	c.Assert(err, gc.IsNil)
+
+	dm := MustNew([]byte("shared root key"), "3rd party caveat", "remote.com")
+	dm1, err := dm.Restrict("discharge restriction")
+	c.Assert(err, gc.IsNil)
+	dm1.Bind(m.Signature())
+	err = m.Verify(rootKey, allow("discharge restriction"), []*macaroon.Macaroon{dm1})
+	c.Assert(err, gc.IsNil)
+	err = m.Verify(rootKey, allow(), []*macaroon.Macaroon{dm1})
+	c.Assert(err, gc.ErrorMatches, `condition "discharge restriction" not met`)
+
+	// The unrestricted discharge is still unbound and unrestricted.
+	c.Assert(dm.Caveats(), gc.HasLen, 0)
+	dm.Bind(m.Signature())
+	err = m.Verify(rootKey, allow(), []*macaroon.Macaroon{dm})
+	c.Assert(err, gc.IsNil)
+}
+
+func (*sliceSuite) TestSliceRestrict(c *gc.C) {
+	rootKey := []byte("secret")
+	m := MustNew(rootKey, "some id", "a location")
+	err := m.AddThirdPartyCaveat([]byte("shared root key"), "3rd party caveat", "remote.com")
+	c.Assert(err, gc.IsNil)
+	dm := MustNew([]byte("shared root key"), "3rd party caveat", "remote.com")
+	s := macaroon.Slice{m, dm}
+	orig, err := s.MarshalBinary()
+	c.Assert(err, gc.IsNil)
+
+	s1, err := s.Restrict("restricted")
+	c.Assert(err, gc.IsNil)
+	c.Assert(s1, gc.HasLen, 2)
+	err = s1[0].Verify(rootKey, allow("restricted"), s1[1:])
+	c.Assert(err, gc.IsNil)
+	err = s1[0].Verify(rootKey, allow(), s1[1:])
+	c.Assert(err, gc.ErrorMatches, `condition "restricted" not met`)
+
+	data, err := s.MarshalBinary()
+	c.Assert(err, gc.IsNil)
+	c.Assert(data, gc.DeepEquals, orig)
+
+	_, err = macaroon.Slice{}.Restrict("x")
+	c.Assert(err, gc.ErrorMatches, `no macaroons in slice`)
+}
+
+// thirdPartySlice returns a slice holding a primary macaroon with
+// a third party caveat whose discharge also has a third party caveat,
+// followed by the unbound discharges.
+func thirdPartySlice(c *gc.C, rootKey []byte) macaroon.Slice {
+	m := MustNew(rootKey, "some id", "a location")
+	err := m.AddThirdPartyCaveat([]byte("bob root key"), "bob caveat", "bob")
+	c.Assert(err, gc.IsNil)
+	dm1 := MustNew([]byte("bob root key"), "bob caveat", "bob")
+	err = dm1.AddThirdPartyCaveat([]byte("charlie root key"), "charlie caveat", "charlie")
+	c.Assert(err, gc.IsNil)
+	dm2 := MustNew([]byte("charlie root key"), "charlie caveat", "charlie")
+	return macaroon.Slice{m, dm1, dm2}
+}
+
+func (*sliceSuite) TestPrimary(c *gc.C) {
+	c.Assert(macaroon.Slice{}.Primary(), gc.IsNil)
+	s := thirdPartySlice(c, []byte("secret"))
+	c.Assert(s.Primary(), gc.Equals, s[0])
+}
+
+func (*sliceSuite) TestBindAndVerify(c *gc.C) {
+	rootKey := []byte("secret")
+	s := thirdPartySlice(c, rootKey)
+	err := s.Verify(rootKey, never)
+	c.Assert(err, gc.ErrorMatches, `signature mismatch after caveat verification`)
+	s.Bind()
+	err = s.Verify(rootKey, never)
+	c.Assert(err, gc.IsNil)
+	err = s.Verify([]byte("wrong key"), never)
+	c.Assert(err, gc.ErrorMatches, `failed to decrypt caveat 0 signature: decryption failure`)
+
+	err = macaroon.Slice{}.Verify(rootKey, never)
+	c.Assert(err, gc.ErrorMatches, `no macaroons in slice`)
+}
+
+func (*sliceSuite) TestPrune(c *gc.C) {
+	rootKey := []byte("secret")
+	s := thirdPartySlice(c, rootKey)
+	unused := MustNew([]byte("other"), "unused caveat", "")
+	duplicate := MustNew([]byte("charlie root key"), "charlie caveat", "charlie")
+	full := macaroon.Slice{s[0], unused, s[1], s[2], duplicate}
+	full.Bind()
+	err := full.Verify(rootKey, never)
+	c.Assert(err, gc.ErrorMatches, `discharge macaroon "unused caveat" was not used`)
+
+	pruned := full.Prune()
+	c.Assert(pruned, gc.DeepEquals, s)
+	c.Assert(full, gc.HasLen, 5)
+	err = pruned.Verify(rootKey, never)
+	c.Assert(err, gc.IsNil)
+
+	c.Assert(macaroon.Slice{}.Prune(), gc.HasLen, 0)
+}
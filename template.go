@@ -0,0 +1,124 @@
+package macaroon
+
+import (
+	"crypto/rand"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/nacl/secretbox"
+)
+
+// Template holds a prototype for minting many macaroons that
+// share the same location and caveats but differ in their
+// root keys and ids. The encoded caveats are prepared once,
+// so minting from a template only needs to copy them and
+// compute the new signatures.
+//
+// Once all its caveats have been added, a Template may be
+// used to mint macaroons concurrently.
+type Template struct {
+	// proto holds a macaroon with an empty id and the caveats of
+	// the template. Each third party caveat has a placeholder
+	// verification id of the correct length.
+	proto Macaroon
+
+	// thirdPartyKeys holds, for each caveat, the key derived from
+	// the third party root key, or nil for a first party caveat.
+	thirdPartyKeys [][]byte
+}
+
+// vidLen holds the length of the verification id
+// of a third party caveat.
+const vidLen = nonceLen + secretbox.Overhead + keyLen
+
+// NewTemplate returns a template for macaroons
+// with the given location.
+func NewTemplate(loc string) (*Template, error) {
+	var t Template
+	if err := t.proto.init("", loc); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// AddFirstPartyCaveat adds a first party caveat
+// to all macaroons minted from the template.
+func (t *Template) AddFirstPartyCaveat(caveatId string) error {
+	if _, err := t.proto.appendCaveat(caveatId, nil, ""); err != nil {
+		return err
+	}
+	t.thirdPartyKeys = append(t.thirdPartyKeys, nil)
+	return nil
+}
+
+// AddThirdPartyCaveat adds a third party caveat to all macaroons
+// minted from the template. See Macaroon.AddThirdPartyCaveat.
+// Each minted macaroon has its own encryption of the root key.
+func (t *Template) AddThirdPartyCaveat(rootKey []byte, caveatId string, loc string) error {
+	var placeholder [vidLen]byte
+	if _, err := t.proto.appendCaveat(caveatId, placeholder[:], loc); err != nil {
+		return err
+	}
+	t.thirdPartyKeys = append(t.thirdPartyKeys, makeKey(rootKey))
+	return nil
+}
+
+// Mint returns a new macaroon with the given root key and id
+// and the location and caveats of the template. It returns
+// the same macaroon as calling New followed by adding each
+// of the template's caveats in turn.
+func (t *Template) Mint(rootKey []byte, id string) (*Macaroon, error) {
+	return t.MintWithRand(rootKey, id, rand.Reader)
+}
+
+// MintWithRand is like Mint except that it uses r as the source
+// of randomness when encrypting third party caveat root keys.
+// See Macaroon.AddThirdPartyCaveatWithRand.
+func (t *Template) MintWithRand(rootKey []byte, id string, r io.Reader) (*Macaroon, error) {
+	p := &t.proto
+	m := &Macaroon{
+		location: p.location,
+		data:     make([]byte, 0, len(p.data)+len(id)),
+		caveats:  make([]caveat, len(p.caveats)),
+	}
+	m.data = append(m.data, p.packetBytes(p.location)...)
+	var ok bool
+	m.id, ok = m.appendPacket(fieldIdentifier, []byte(id))
+	if !ok {
+		return nil, fmt.Errorf("macaroon identifier too big")
+	}
+	// Copy the caveats, moving their packets to
+	// allow for the length of the new id.
+	caveatsStart := p.id.start + int32(p.id.len())
+	offset := int32(len(m.data)) - caveatsStart
+	m.data = append(m.data, p.data[caveatsStart:]...)
+	for i, cav := range p.caveats {
+		m.caveats[i] = caveat{
+			caveatId:       cav.caveatId.shift(offset),
+			verificationId: cav.verificationId.shift(offset),
+			location:       cav.location.shift(offset),
+		}
+	}
+
+	sc := getScratch()
+	defer putScratch(sc)
+	sc.keyedHash(&sc.rootKey, keyGenerator, rootKey)
+	sc.keyedHash(&sc.sig, sc.rootKey[:], m.dataBytes(m.id))
+	for i := range m.caveats {
+		cav := &m.caveats[i]
+		if key := t.thirdPartyKeys[i]; key != nil {
+			vid, err := encrypt(sc.sig[:], key, r)
+			if err != nil {
+				return nil, err
+			}
+			dst := m.dataBytes(cav.verificationId)
+			if len(vid) != len(dst) {
+				return nil, fmt.Errorf("unexpected verification id length %d", len(vid))
+			}
+			copy(dst, vid)
+		}
+		m.scratchCaveatSig(sc, cav)
+	}
+	m.sig = append([]byte(nil), sc.sig[:]...)
+	return m, nil
+}
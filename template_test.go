@@ -0,0 +1,96 @@
+package macaroon_test
+
+import (
+	"fmt"
+
+	gc "gopkg.in/check.v1"
+
+	"github.com/iron-io/macaroon"
+)
+
+type templateSuite struct{}
+
+var _ = gc.Suite(&templateSuite{})
+
+// templateCaveats holds the caveats used by newTestTemplate.
+var templateCaveats = []caveat{{
+	condition: "wonderful",
+}, {
+	condition: "bob-is-great",
+	location:  "bob",
+	rootKey:   "bob-caveat-root-key",
+}, {
+	condition: "splendid",
+}}
+
+func newTestTemplate(c *gc.C) *macaroon.Template {
+	t, err := macaroon.NewTemplate("a location")
+	c.Assert(err, gc.IsNil)
+	for _, cav := range templateCaveats {
+		if cav.location == "" {
+			err = t.AddFirstPartyCaveat(cav.condition)
+		} else {
+			err = t.AddThirdPartyCaveat([]byte(cav.rootKey), cav.condition, cav.location)
+		}
+		c.Assert(err, gc.IsNil)
+	}
+	return t
+}
+
+func (*templateSuite) TestMintMatchesNew(c *gc.C) {
+	t := newTestTemplate(c)
+	for i, id := range []string{"", "id", "a much longer id than the others"} {
+		c.Logf("test %d: id %q", i, id)
+		rootKey := []byte(fmt.Sprint("root key ", i))
+		m0, err := t.MintWithRand(rootKey, id, zeroReader{})
+		c.Assert(err, gc.IsNil)
+
+		m1 := MustNew(rootKey, id, "a location")
+		for _, cav := range templateCaveats {
+			if cav.location == "" {
+				err = m1.AddFirstPartyCaveat(cav.condition)
+			} else {
+				err = m1.AddThirdPartyCaveatWithRand([]byte(cav.rootKey), cav.condition, cav.location, zeroReader{})
+			}
+			c.Assert(err, gc.IsNil)
+		}
+		c.Assert(m0.Equal(m1), gc.Equals, true)
+		c.Assert(mustMarshalBinary(m0), gc.DeepEquals, mustMarshalBinary(m1))
+	}
+}
+
+func (*templateSuite) TestMintedMacaroonsVerify(c *gc.C) {
+	t := newTestTemplate(c)
+	rootKey := []byte("secret")
+	m0, err := t.Mint(rootKey, "id0")
+	c.Assert(err, gc.IsNil)
+	m1, err := t.Mint(rootKey, "id1")
+	c.Assert(err, gc.IsNil)
+	for _, m := range []*macaroon.Macaroon{m0, m1} {
+		dm := MustNew([]byte("bob-caveat-root-key"), "bob-is-great", "bob")
+		dm.Bind(m.Signature())
+		err = m.Verify(rootKey, allow("wonderful", "splendid"), []*macaroon.Macaroon{dm})
+		c.Assert(err, gc.IsNil)
+	}
+	// Each minted macaroon has its own random verification id.
+	c.Assert(m0.Caveats(), gc.DeepEquals, m1.Caveats())
+	c.Assert(m0.Equal(m1), gc.Equals, false)
+
+	// Adding caveats to a minted macaroon does not
+	// affect the template or other minted macaroons.
+	err = m0.AddFirstPartyCaveat("extra")
+	c.Assert(err, gc.IsNil)
+	m2, err := t.Mint(rootKey, "id0")
+	c.Assert(err, gc.IsNil)
+	c.Assert(m2.Caveats(), gc.HasLen, len(templateCaveats))
+	c.Assert(m1.Caveats(), gc.HasLen, len(templateCaveats))
+}
+
+func (*templateSuite) TestMintErrors(c *gc.C) {
+	t := newTestTemplate(c)
+	_, err := t.MintWithRand([]byte("secret"), "id", &macaroon.ErrorReader{})
+	c.Assert(err, gc.ErrorMatches, `cannot generate random bytes: fail`)
+
+	_, err = t.Mint([]byte("secret"), string(make([]byte, 65535)))
+	c.Assert(err, gc.ErrorMatches, `macaroon identifier too big`)
+}
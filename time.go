@@ -0,0 +1,108 @@
+package macaroon
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+const (
+	// CondTimeBefore is the condition name of
+	// caveats created by TimeBeforeCaveat.
+	CondTimeBefore = "time-before"
+
+	// CondTimeAfter is the condition name of
+	// caveats created by TimeAfterCaveat.
+	CondTimeAfter = "time-after"
+)
+
+// TimeBeforeCaveat returns a first party caveat that is
+// satisfied only before the given time.
+func TimeBeforeCaveat(t time.Time) string {
+	return Condition(CondTimeBefore, t.UTC().Format(time.RFC3339Nano))
+}
+
+// TimeAfterCaveat returns a first party caveat that is
+// satisfied only after the given time. It can be used
+// to create a macaroon that is not valid until some
+// time in the future.
+func TimeAfterCaveat(t time.Time) string {
+	return Condition(CondTimeAfter, t.UTC().Format(time.RFC3339Nano))
+}
+
+func parseTime(arg string) (time.Time, error) {
+	t, err := time.Parse(time.RFC3339Nano, arg)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("cannot parse time %q: %v", arg, err)
+	}
+	return t, nil
+}
+
+// now returns the current time according to c.Clock.
+func (c *Checker) now() time.Time {
+	if c.Clock != nil {
+		return c.Clock()
+	}
+	return time.Now()
+}
+
+func (c *Checker) checkTimeBefore(ctx context.Context, _, arg string) error {
+	t, err := parseTime(arg)
+	if err != nil {
+		return err
+	}
+	if !c.now().Add(-c.Skew).Before(t) {
+		return fmt.Errorf("macaroon has expired")
+	}
+	return nil
+}
+
+func (c *Checker) checkTimeAfter(ctx context.Context, _, arg string) error {
+	t, err := parseTime(arg)
+	if err != nil {
+		return err
+	}
+	if c.now().Add(c.Skew).Before(t) {
+		return fmt.Errorf("macaroon is not yet valid")
+	}
+	return nil
+}
+
+// Expiry returns the earliest time in any time-before caveat
+// in m, and reports whether there was such a caveat. Caveats
+// that cannot be parsed are ignored.
+func (m *Macaroon) Expiry() (time.Time, bool) {
+	var expiry time.Time
+	found := false
+	for _, cav := range m.caveats {
+		if cav.isThirdParty() {
+			continue
+		}
+		name, arg, err := ParseCaveat(m.dataStr(cav.caveatId))
+		if err != nil || name != CondTimeBefore {
+			continue
+		}
+		t, err := parseTime(arg)
+		if err != nil {
+			continue
+		}
+		if !found || t.Before(expiry) {
+			expiry, found = t, true
+		}
+	}
+	return expiry, found
+}
+
+// Expiry returns the earliest expiry time (see Macaroon.Expiry)
+// of any macaroon in s, and reports whether any macaroon had
+// an expiry time. After that time, s will fail verification.
+func (s Slice) Expiry() (time.Time, bool) {
+	var expiry time.Time
+	found := false
+	for _, m := range s {
+		if t, ok := m.Expiry(); ok && (!found || t.Before(expiry)) {
+			expiry, found = t, true
+		}
+	}
+	return expiry, found
+}
@@ -0,0 +1,118 @@
+package macaroon_test
+
+import (
+	"context"
+	"time"
+
+	gc "gopkg.in/check.v1"
+
+	"github.com/iron-io/macaroon"
+)
+
+type timeSuite struct{}
+
+var _ = gc.Suite(&timeSuite{})
+
+var t0 = time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+
+var timeCaveatTests = []struct {
+	about     string
+	caveat    string
+	now       time.Time
+	skew      time.Duration
+	expectErr string
+}{{
+	about:  "before expiry",
+	caveat: macaroon.TimeBeforeCaveat(t0),
+	now:    t0.Add(-time.Second),
+}, {
+	about:     "at expiry",
+	caveat:    macaroon.TimeBeforeCaveat(t0),
+	now:       t0,
+	expectErr: `caveat "time-before 2020-06-01T12:00:00Z" not satisfied: macaroon has expired`,
+}, {
+	about:  "after expiry within skew",
+	caveat: macaroon.TimeBeforeCaveat(t0),
+	now:    t0.Add(30 * time.Second),
+	skew:   time.Minute,
+}, {
+	about:     "after expiry beyond skew",
+	caveat:    macaroon.TimeBeforeCaveat(t0),
+	now:       t0.Add(2 * time.Minute),
+	skew:      time.Minute,
+	expectErr: `.*macaroon has expired`,
+}, {
+	about:  "after not-before time",
+	caveat: macaroon.TimeAfterCaveat(t0),
+	now:    t0.Add(time.Second),
+}, {
+	about:     "before not-before time",
+	caveat:    macaroon.TimeAfterCaveat(t0),
+	now:       t0.Add(-time.Second),
+	expectErr: `caveat "time-after 2020-06-01T12:00:00Z" not satisfied: macaroon is not yet valid`,
+}, {
+	about:  "before not-before time within skew",
+	caveat: macaroon.TimeAfterCaveat(t0),
+	now:    t0.Add(-30 * time.Second),
+	skew:   time.Minute,
+}, {
+	about:     "bad time",
+	caveat:    "time-before yesterday",
+	now:       t0,
+	expectErr: `caveat "time-before yesterday" not satisfied: cannot parse time "yesterday": .*`,
+}, {
+	about:  "non-UTC time zone",
+	caveat: "time-before 2020-06-01T13:00:00+02:00",
+	now:    t0.Add(-time.Hour - time.Second),
+}}
+
+func (*timeSuite) TestTimeCaveats(c *gc.C) {
+	for i, test := range timeCaveatTests {
+		c.Logf("test %d: %s", i, test.about)
+		checker := macaroon.NewChecker()
+		checker.Clock = func() time.Time {
+			return test.now
+		}
+		checker.Skew = test.skew
+		err := checker.CheckFirstPartyCaveat(context.Background(), test.caveat)
+		if test.expectErr != "" {
+			c.Assert(err, gc.ErrorMatches, test.expectErr)
+		} else {
+			c.Assert(err, gc.IsNil)
+		}
+	}
+}
+
+func (*timeSuite) TestExpiry(c *gc.C) {
+	rootKey := []byte("secret")
+	m := MustNew(rootKey, "some id", "")
+	_, ok := m.Expiry()
+	c.Assert(ok, gc.Equals, false)
+
+	for _, cav := range []string{
+		macaroon.TimeBeforeCaveat(t0.Add(time.Hour)),
+		macaroon.TimeAfterCaveat(t0.Add(-time.Hour)),
+		macaroon.TimeBeforeCaveat(t0.Add(time.Minute)),
+		"time-before bad",
+		macaroon.TimeBeforeCaveat(t0.Add(2 * time.Hour)),
+	} {
+		err := m.AddFirstPartyCaveat(cav)
+		c.Assert(err, gc.IsNil)
+	}
+	expiry, ok := m.Expiry()
+	c.Assert(ok, gc.Equals, true)
+	c.Assert(expiry.Equal(t0.Add(time.Minute)), gc.Equals, true)
+
+	err := m.AddThirdPartyCaveat([]byte("third party key"), "cond", "remote")
+	c.Assert(err, gc.IsNil)
+	dm := MustNew([]byte("third party key"), "cond", "remote")
+	err = dm.AddFirstPartyCaveat(macaroon.TimeBeforeCaveat(t0.Add(30 * time.Second)))
+	c.Assert(err, gc.IsNil)
+	s := macaroon.Slice{m, dm}
+	expiry, ok = s.Expiry()
+	c.Assert(ok, gc.Equals, true)
+	c.Assert(expiry.Equal(t0.Add(30*time.Second)), gc.Equals, true)
+
+	_, ok = macaroon.Slice{}.Expiry()
+	c.Assert(ok, gc.Equals, false)
+}
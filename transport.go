@@ -0,0 +1,194 @@
+package macaroon
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// MacaroonsHeader holds the name of the HTTP header used by
+// Transport to send a Slice, encoded with Slice.MarshalText.
+const MacaroonsHeader = "Macaroons"
+
+// CodeDischargeRequired holds the code in a
+// DischargeRequiredResponse.
+const CodeDischargeRequired = "macaroon-discharge-required"
+
+// DischargeRequiredResponse holds the JSON body of a 401 response
+// telling a client that it must acquire discharges for the given
+// macaroon and then retry the request with it. See
+// WriteDischargeRequired.
+type DischargeRequiredResponse struct {
+	Code     string    `json:"code"`
+	Message  string    `json:"message,omitempty"`
+	Macaroon *Macaroon `json:"macaroon"`
+}
+
+// WriteDischargeRequired writes a 401 response to w holding a
+// DischargeRequiredResponse with the given macaroon and message.
+func WriteDischargeRequired(w http.ResponseWriter, m *Macaroon, msg string) error {
+	data, err := json.Marshal(&DischargeRequiredResponse{
+		Code:     CodeDischargeRequired,
+		Message:  msg,
+		Macaroon: m,
+	})
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnauthorized)
+	_, err = w.Write(data)
+	return err
+}
+
+// Transport is an http.RoundTripper that adds a Slice to each request
+// in the MacaroonsHeader header. When the server responds with a
+// DischargeRequiredResponse, Transport acquires discharges for the
+// macaroon in it, stores the resulting Slice for use in later
+// requests, and retries the request once.
+//
+// Slices are stored separately for each host (including any port),
+// and a request carries only the Slice stored for its own host, so
+// that macaroons are not sent to other hosts, including the targets
+// of redirects. Note that the discharger is asked to acquire
+// discharges from the locations named in the server's macaroon.
+//
+// A request with a body can only be retried if its GetBody
+// field is set, as it is by http.NewRequest for common body
+// types; otherwise the 401 response is returned unchanged.
+//
+// It is safe to use concurrently.
+type Transport struct {
+	// Base holds the transport used to make requests.
+	// If it is nil, http.DefaultTransport is used.
+	Base http.RoundTripper
+
+	// Discharger holds the discharger used to acquire
+	// discharges. If it is nil, an HTTPDischarger
+	// using http.DefaultClient is used.
+	Discharger Discharger
+
+	mu     sync.Mutex
+	slices map[string]Slice
+}
+
+// Slice returns the Slice currently sent
+// with requests to the given host.
+func (t *Transport) Slice(host string) Slice {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.slices[host]
+}
+
+// SetSlice sets the Slice to send with
+// requests to the given host.
+func (t *Transport) SetSlice(host string, s Slice) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.slices == nil {
+		t.slices = make(map[string]Slice)
+	}
+	t.slices[host] = s
+}
+
+func (t *Transport) base() http.RoundTripper {
+	if t.Base != nil {
+		return t.Base
+	}
+	return http.DefaultTransport
+}
+
+func (t *Transport) discharger() Discharger {
+	if t.Discharger != nil {
+		return t.Discharger
+	}
+	return &HTTPDischarger{}
+}
+
+// RoundTrip implements http.RoundTripper.RoundTrip.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	host := req.URL.Host
+	req1, err := t.withSlice(req, t.Slice(host))
+	if err != nil {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, err
+	}
+	resp, err := t.base().RoundTrip(req1)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return resp, nil
+	}
+	m, err := readDischargeRequired(resp)
+	if err != nil {
+		return nil, err
+	}
+	if m == nil {
+		return resp, nil
+	}
+	s, err := DischargeAll(req.Context(), m, t.discharger())
+	if err != nil {
+		return nil, fmt.Errorf("cannot acquire discharges: %v", err)
+	}
+	t.SetSlice(host, s)
+	req2, err := t.withSlice(req, s)
+	if err != nil {
+		return nil, err
+	}
+	if req.GetBody != nil {
+		if req2.Body, err = req.GetBody(); err != nil {
+			return nil, fmt.Errorf("cannot get request body for retry: %v", err)
+		}
+	}
+	return t.base().RoundTrip(req2)
+}
+
+// withSlice returns a copy of req with s in its MacaroonsHeader
+// header. The original request is not changed.
+func (t *Transport) withSlice(req *http.Request, s Slice) (*http.Request, error) {
+	req1 := req.Clone(req.Context())
+	// Clone does not copy the body, so share it with the original.
+	req1.Body = req.Body
+	if len(s) == 0 {
+		return req1, nil
+	}
+	text, err := s.MarshalText()
+	if err != nil {
+		return nil, fmt.Errorf("cannot marshal macaroons: %v", err)
+	}
+	req1.Header.Set(MacaroonsHeader, string(text))
+	return req1, nil
+}
+
+// readDischargeRequired returns the macaroon in resp if it holds a
+// DischargeRequiredResponse, in which case the body is closed.
+// Otherwise it returns nil and replaces the body so that it can
+// still be read in full by the caller.
+func readDischargeRequired(resp *http.Response) (*Macaroon, error) {
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json") {
+		return nil, nil
+	}
+	body := resp.Body
+	data, err := io.ReadAll(io.LimitReader(body, maxResponseSize))
+	if err != nil {
+		body.Close()
+		return nil, fmt.Errorf("cannot read response: %v", err)
+	}
+	var dresp DischargeRequiredResponse
+	if err := json.Unmarshal(data, &dresp); err != nil || dresp.Code != CodeDischargeRequired || dresp.Macaroon == nil {
+		resp.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(data), body), body}
+		return nil, nil
+	}
+	body.Close()
+	return dresp.Macaroon, nil
+}
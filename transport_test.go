@@ -0,0 +1,326 @@
+package macaroon_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+
+	gc "gopkg.in/check.v1"
+
+	"github.com/iron-io/macaroon"
+)
+
+type transportSuite struct{}
+
+var _ = gc.Suite(&transportSuite{})
+
+// dischargeServer returns a server that discharges caveats
+// created with the given key, counting the discharges in *count.
+func dischargeServer(c *gc.C, key []byte, count *int) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/discharge", func(w http.ResponseWriter, req *http.Request) {
+		*count++
+		id := req.PostFormValue("id")
+		if id != "is-ok" {
+			http.Error(w, "bad caveat", http.StatusForbidden)
+			return
+		}
+		dm := MustNew(key, id, "")
+		fmt.Fprintf(w, `{"macaroon": %s}`, mustMarshalJSON(c, dm))
+	})
+	return httptest.NewServer(mux)
+}
+
+func mustMarshalJSON(c *gc.C, m *macaroon.Macaroon) []byte {
+	data, err := m.MarshalJSON()
+	c.Assert(err, gc.IsNil)
+	return data
+}
+
+// protectedServer returns a server that requires macaroons with a
+// third party caveat addressed to the given location, echoing the
+// request body when they verify, and counting requests in *count.
+func protectedServer(c *gc.C, rootKey, thirdPartyKey []byte, loc string, count *int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		*count++
+		var s macaroon.Slice
+		err := s.UnmarshalText([]byte(req.Header.Get(macaroon.MacaroonsHeader)))
+		if err == nil {
+			err = s.Verify(rootKey, never)
+		}
+		if err != nil {
+			m := MustNew(rootKey, "some id", "")
+			err := m.AddThirdPartyCaveat(thirdPartyKey, "is-ok", loc)
+			c.Check(err, gc.IsNil)
+			macaroon.WriteDischargeRequired(w, m, "verification failed")
+			return
+		}
+		io.Copy(w, req.Body)
+	}))
+}
+
+func (*transportSuite) TestTransportAcquiresDischarges(c *gc.C) {
+	rootKey, thirdPartyKey := []byte("secret"), []byte("third party key")
+	var dischargeCount, serverCount int
+	dsrv := dischargeServer(c, thirdPartyKey, &dischargeCount)
+	defer dsrv.Close()
+	srv := protectedServer(c, rootKey, thirdPartyKey, dsrv.URL, &serverCount)
+	defer srv.Close()
+
+	transport := &macaroon.Transport{}
+	client := &http.Client{Transport: transport}
+	resp, err := client.Post(srv.URL, "text/plain", strings.NewReader("hello"))
+	c.Assert(err, gc.IsNil)
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusOK)
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	c.Assert(err, gc.IsNil)
+	c.Assert(string(body), gc.Equals, "hello")
+	c.Assert(serverCount, gc.Equals, 2)
+	c.Assert(dischargeCount, gc.Equals, 1)
+
+	s := transport.Slice(serverHost(c, srv))
+	c.Assert(s, gc.HasLen, 2)
+	c.Assert(s.Verify(rootKey, never), gc.IsNil)
+
+	// The stored macaroons are used for later requests.
+	resp, err = client.Get(srv.URL)
+	c.Assert(err, gc.IsNil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusOK)
+	c.Assert(serverCount, gc.Equals, 3)
+	c.Assert(dischargeCount, gc.Equals, 1)
+}
+
+func serverHost(c *gc.C, srv *httptest.Server) string {
+	u, err := url.Parse(srv.URL)
+	c.Assert(err, gc.IsNil)
+	return u.Host
+}
+
+// headerServer returns a server that records the
+// MacaroonsHeader header of each request in *got.
+func headerServer(got *[]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		*got = append(*got, req.Header.Get(macaroon.MacaroonsHeader))
+	}))
+}
+
+func (*transportSuite) TestTransportSlicesPerHost(c *gc.C) {
+	rootKey, thirdPartyKey := []byte("secret"), []byte("third party key")
+	var dischargeCount, serverCount int
+	dsrv := dischargeServer(c, thirdPartyKey, &dischargeCount)
+	defer dsrv.Close()
+	srv := protectedServer(c, rootKey, thirdPartyKey, dsrv.URL, &serverCount)
+	defer srv.Close()
+	var otherHeaders []string
+	other := headerServer(&otherHeaders)
+	defer other.Close()
+
+	transport := &macaroon.Transport{}
+	client := &http.Client{Transport: transport}
+	resp, err := client.Get(srv.URL)
+	c.Assert(err, gc.IsNil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusOK)
+	c.Assert(transport.Slice(serverHost(c, srv)), gc.HasLen, 2)
+
+	// The macaroons are not sent to another host.
+	resp, err = client.Get(other.URL)
+	c.Assert(err, gc.IsNil)
+	resp.Body.Close()
+	c.Assert(otherHeaders, gc.DeepEquals, []string{""})
+	c.Assert(transport.Slice(serverHost(c, other)), gc.HasLen, 0)
+
+	// Another host asking for discharges does not
+	// replace the macaroons for the first.
+	var otherCount int
+	other2 := protectedServer(c, []byte("other root key"), thirdPartyKey, dsrv.URL, &otherCount)
+	defer other2.Close()
+	resp, err = client.Get(other2.URL)
+	c.Assert(err, gc.IsNil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusOK)
+	c.Assert(transport.Slice(serverHost(c, other2)), gc.HasLen, 2)
+	s := transport.Slice(serverHost(c, srv))
+	c.Assert(s, gc.HasLen, 2)
+	c.Assert(s.Verify(rootKey, never), gc.IsNil)
+}
+
+func (*transportSuite) TestTransportCrossHostRedirect(c *gc.C) {
+	rootKey, thirdPartyKey := []byte("secret"), []byte("third party key")
+	var dischargeCount, serverCount int
+	dsrv := dischargeServer(c, thirdPartyKey, &dischargeCount)
+	defer dsrv.Close()
+	var otherHeaders []string
+	other := headerServer(&otherHeaders)
+	defer other.Close()
+	protected := protectedServer(c, rootKey, thirdPartyKey, dsrv.URL, &serverCount)
+	defer protected.Close()
+	// The server redirects to the other host once the
+	// macaroons have been verified.
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/redirect" {
+			http.Redirect(w, req, other.URL, http.StatusFound)
+			return
+		}
+		protected.Config.Handler.ServeHTTP(w, req)
+	}))
+	defer srv.Close()
+
+	transport := &macaroon.Transport{}
+	client := &http.Client{Transport: transport}
+	resp, err := client.Get(srv.URL)
+	c.Assert(err, gc.IsNil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusOK)
+
+	resp, err = client.Get(srv.URL + "/redirect")
+	c.Assert(err, gc.IsNil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusOK)
+	c.Assert(otherHeaders, gc.DeepEquals, []string{""})
+}
+
+func (*transportSuite) TestTransportRetriesOnce(c *gc.C) {
+	rootKey := []byte("secret")
+	var dischargeCount, serverCount int
+	dsrv := dischargeServer(c, []byte("third party key"), &dischargeCount)
+	defer dsrv.Close()
+	// The server expects a different third party key from the one
+	// used by the discharger, so the discharges never verify.
+	srv := protectedServer(c, rootKey, []byte("other key"), dsrv.URL, &serverCount)
+	defer srv.Close()
+
+	client := &http.Client{Transport: &macaroon.Transport{}}
+	resp, err := client.Get(srv.URL)
+	c.Assert(err, gc.IsNil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusUnauthorized)
+	c.Assert(serverCount, gc.Equals, 2)
+	c.Assert(dischargeCount, gc.Equals, 1)
+}
+
+func (*transportSuite) TestTransportDischargeError(c *gc.C) {
+	var dischargeCount, serverCount int
+	dsrv := dischargeServer(c, nil, &dischargeCount)
+	defer dsrv.Close()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		serverCount++
+		m := MustNew([]byte("secret"), "some id", "")
+		err := m.AddThirdPartyCaveat([]byte("key"), "is-not-ok", dsrv.URL)
+		c.Check(err, gc.IsNil)
+		macaroon.WriteDischargeRequired(w, m, "")
+	}))
+	defer srv.Close()
+
+	client := &http.Client{Transport: &macaroon.Transport{}}
+	_, err := client.Get(srv.URL)
+	c.Assert(err, gc.ErrorMatches, `Get ".*": cannot acquire discharges: cannot get discharge for caveat "is-not-ok" from ".*": discharge request failed with status "403 Forbidden"`)
+	c.Assert(serverCount, gc.Equals, 1)
+	c.Assert(dischargeCount, gc.Equals, 1)
+}
+
+func (*transportSuite) TestTransportOtherUnauthorized(c *gc.C) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprint(w, `{"code": "something else"}`)
+	}))
+	defer srv.Close()
+
+	client := &http.Client{Transport: &macaroon.Transport{}}
+	resp, err := client.Get(srv.URL)
+	c.Assert(err, gc.IsNil)
+	defer resp.Body.Close()
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusUnauthorized)
+	body, err := io.ReadAll(resp.Body)
+	c.Assert(err, gc.IsNil)
+	c.Assert(string(body), gc.Equals, `{"code": "something else"}`)
+}
+
+func (*transportSuite) TestTransportBodyNotReplayable(c *gc.C) {
+	rootKey, thirdPartyKey := []byte("secret"), []byte("third party key")
+	var dischargeCount, serverCount int
+	dsrv := dischargeServer(c, thirdPartyKey, &dischargeCount)
+	defer dsrv.Close()
+	srv := protectedServer(c, rootKey, thirdPartyKey, dsrv.URL, &serverCount)
+	defer srv.Close()
+
+	req, err := http.NewRequest("POST", srv.URL, io.MultiReader(strings.NewReader("hello")))
+	c.Assert(err, gc.IsNil)
+	c.Assert(req.GetBody, gc.IsNil)
+	client := &http.Client{Transport: &macaroon.Transport{}}
+	resp, err := client.Do(req)
+	c.Assert(err, gc.IsNil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusUnauthorized)
+	c.Assert(serverCount, gc.Equals, 1)
+	c.Assert(dischargeCount, gc.Equals, 0)
+}
+
+type mapDischarger map[string]*macaroon.Macaroon
+
+func (d mapDischarger) Discharge(ctx context.Context, cav macaroon.Caveat) (*macaroon.Macaroon, error) {
+	if dm := d[cav.Id]; dm != nil {
+		return dm, nil
+	}
+	return nil, fmt.Errorf("no discharge for %q", cav.Id)
+}
+
+func (*transportSuite) TestDischargeAll(c *gc.C) {
+	rootKey := []byte("secret")
+	m := MustNew(rootKey, "some id", "")
+	err := m.AddThirdPartyCaveat([]byte("bob key"), "bob-cav", "bob")
+	c.Assert(err, gc.IsNil)
+	bob := MustNew([]byte("bob key"), "bob-cav", "bob")
+	err = bob.AddThirdPartyCaveat([]byte("charlie key"), "charlie-cav", "charlie")
+	c.Assert(err, gc.IsNil)
+	charlie := MustNew([]byte("charlie key"), "charlie-cav", "charlie")
+
+	d := mapDischarger{
+		"bob-cav":     bob,
+		"charlie-cav": charlie,
+	}
+	s, err := macaroon.DischargeAll(context.Background(), m, d)
+	c.Assert(err, gc.IsNil)
+	c.Assert(s, gc.HasLen, 3)
+	c.Assert(s[0], gc.Equals, m)
+	c.Assert(s.Verify(rootKey, never), gc.IsNil)
+
+	delete(d, "charlie-cav")
+	_, err = macaroon.DischargeAll(context.Background(), MustNew(rootKey, "other id", ""), d)
+	c.Assert(err, gc.IsNil)
+	_, err = macaroon.DischargeAll(context.Background(), m, d)
+	c.Assert(err, gc.ErrorMatches, `cannot get discharge for caveat "charlie-cav" from "charlie": no discharge for "charlie-cav"`)
+}
+
+func (*transportSuite) TestDischargeAllLimit(c *gc.C) {
+	rootKey := []byte("secret")
+	m := MustNew(rootKey, "some id", "")
+	err := m.AddThirdPartyCaveat([]byte("bob key"), "bob-cav", "bob")
+	c.Assert(err, gc.IsNil)
+	err = m.AddThirdPartyCaveat([]byte("charlie key"), "charlie-cav", "charlie")
+	c.Assert(err, gc.IsNil)
+	d := mapDischarger{
+		"bob-cav":     MustNew([]byte("bob key"), "bob-cav", "bob"),
+		"charlie-cav": MustNew([]byte("charlie key"), "charlie-cav", "charlie"),
+	}
+
+	defer func(old int) {
+		macaroon.DefaultLimits.MaxDischarges = old
+	}(macaroon.DefaultLimits.MaxDischarges)
+	macaroon.DefaultLimits.MaxDischarges = 1
+	_, err = macaroon.DischargeAll(context.Background(), m, d)
+	c.Assert(err, gc.ErrorMatches, `macaroon limit exceeded: MaxDischarges is 1`)
+
+	// A zero limit means that there is no limit.
+	macaroon.DefaultLimits.MaxDischarges = 0
+	s, err := macaroon.DischargeAll(context.Background(), m, d)
+	c.Assert(err, gc.IsNil)
+	c.Assert(s, gc.HasLen, 3)
+}
@@ -0,0 +1,203 @@
+package macaroon
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// CondUses is the condition name of caveats
+// created by UsesCaveat.
+const CondUses = "uses"
+
+// usesNonceLen holds the number of random bytes
+// in nonces generated by NewUsesCaveat.
+const usesNonceLen = 16
+
+// UsesCaveat returns a first party caveat that is satisfied at most
+// maxUses times. Each time a Slice holding the caveat is verified
+// successfully by Checker.Verify, whether or not the result was
+// cached (see VerifyOptions.Cache), a use of the given nonce is recorded
+// in the checker's UsageStore; once maxUses uses have been recorded,
+// verification fails with a *UsageExhaustedError. Uses are recorded
+// only after all the signatures and other caveats have been verified,
+// so a forged or otherwise invalid Slice does not use up the nonce.
+// The nonce must be non-empty, must not contain a space and should
+// be unique to the macaroon; see NewUsesCaveat.
+//
+// The caveat may be added to a primary macaroon or to a discharge
+// macaroon.
+func UsesCaveat(nonce string, maxUses int) string {
+	return Condition(CondUses, nonce+" "+strconv.Itoa(maxUses))
+}
+
+// NewUsesCaveat is like UsesCaveat except that it uses a new
+// random nonce, read from r, or from rand.Reader if r is nil.
+func NewUsesCaveat(r io.Reader, maxUses int) (string, error) {
+	if r == nil {
+		r = rand.Reader
+	}
+	var nonce [usesNonceLen]byte
+	if _, err := io.ReadFull(r, nonce[:]); err != nil {
+		return "", fmt.Errorf("cannot generate random bytes: %v", err)
+	}
+	return UsesCaveat(hex.EncodeToString(nonce[:]), maxUses), nil
+}
+
+// ParseUses parses the argument of a uses caveat,
+// returning the nonce and the maximum number of uses.
+func ParseUses(arg string) (nonce string, maxUses int, err error) {
+	i := strings.IndexByte(arg, ' ')
+	if i <= 0 {
+		return "", 0, fmt.Errorf("invalid uses caveat %q", arg)
+	}
+	maxUses, err = strconv.Atoi(arg[i+1:])
+	if err != nil || maxUses <= 0 {
+		return "", 0, fmt.Errorf("invalid maximum uses %q", arg[i+1:])
+	}
+	return arg[0:i], maxUses, nil
+}
+
+// UsageStore is used by Checker to record uses of
+// macaroons with caveats created by UsesCaveat.
+type UsageStore interface {
+	// Use records a use of the given nonce if fewer than
+	// maxUses uses have already been recorded, and reports
+	// whether it did so. The check and increment must be
+	// atomic, so that concurrent callers cannot together
+	// record more than maxUses uses.
+	Use(nonce string, maxUses int) (bool, error)
+}
+
+// UsageExhaustedError is the error returned when checking
+// a uses caveat whose nonce has been used the maximum
+// number of times.
+type UsageExhaustedError struct {
+	Nonce   string
+	MaxUses int
+}
+
+func (e *UsageExhaustedError) Error() string {
+	if e.MaxUses == 1 {
+		return fmt.Sprintf("nonce %q has already been used", e.Nonce)
+	}
+	return fmt.Sprintf("nonce %q has already been used %d times", e.Nonce, e.MaxUses)
+}
+
+// MemUsageStore is a UsageStore that holds its usage
+// counts in memory. It is safe to use concurrently.
+type MemUsageStore struct {
+	mu   sync.Mutex
+	uses map[string]int
+}
+
+// NewMemUsageStore returns a new, empty,
+// in-memory usage store.
+func NewMemUsageStore() *MemUsageStore {
+	return &MemUsageStore{
+		uses: make(map[string]int),
+	}
+}
+
+// Use implements UsageStore.Use.
+func (s *MemUsageStore) Use(nonce string, maxUses int) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n, ok := s.uses[nonce]
+	if n >= maxUses {
+		return false, nil
+	}
+	if !ok {
+		// The nonce may refer to the data of the macaroon
+		// being verified, so copy it before retaining it.
+		nonce = strings.Clone(nonce)
+	}
+	s.uses[nonce] = n + 1
+	return true, nil
+}
+
+// Uses returns the number of uses recorded for the given nonce.
+func (s *MemUsageStore) Uses(nonce string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.uses[nonce]
+}
+
+// usesKey is the context key for the uses
+// collected during Checker.Verify.
+type usesKey struct{}
+
+// pendingUses holds the uses caveats found while verifying
+// a Slice, which are recorded only once the verification
+// has succeeded.
+type pendingUses struct {
+	mu sync.Mutex
+	// uses maps from each nonce to the
+	// lowest maximum number of uses found.
+	uses map[string]int
+}
+
+func (p *pendingUses) add(nonce string, maxUses int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.uses == nil {
+		p.uses = make(map[string]int)
+	}
+	if n, ok := p.uses[nonce]; !ok || maxUses < n {
+		// The nonce may refer to the data of the macaroon
+		// being verified, so copy it before retaining it.
+		p.uses[strings.Clone(nonce)] = maxUses
+	}
+}
+
+// commit records a use of each nonce in p in the given store.
+// The nonces are used in sorted order; if one of them has been
+// exhausted, the uses already recorded for the others are not
+// undone.
+func (p *pendingUses) commit(store UsageStore) error {
+	nonces := make([]string, 0, len(p.uses))
+	for nonce := range p.uses {
+		nonces = append(nonces, nonce)
+	}
+	sort.Strings(nonces)
+	for _, nonce := range nonces {
+		maxUses := p.uses[nonce]
+		ok, err := store.Use(nonce, maxUses)
+		if err != nil {
+			return fmt.Errorf("cannot record use: %v", err)
+		}
+		if !ok {
+			return &UsageExhaustedError{
+				Nonce:   nonce,
+				MaxUses: maxUses,
+			}
+		}
+	}
+	return nil
+}
+
+// checkUses checks the syntax of a uses caveat and notes its nonce
+// so that Checker.Verify can record the use once the macaroons
+// have been verified. Recording the use here would allow a forged
+// macaroon to use up the nonce of a genuine one.
+func (c *Checker) checkUses(ctx context.Context, _, arg string) error {
+	nonce, maxUses, err := ParseUses(arg)
+	if err != nil {
+		return err
+	}
+	if c.Usage == nil {
+		return fmt.Errorf("no usage store")
+	}
+	p, _ := ctx.Value(usesKey{}).(*pendingUses)
+	if p == nil {
+		return fmt.Errorf("uses caveats can only be checked by Checker.Verify")
+	}
+	p.add(nonce, maxUses)
+	return nil
+}
@@ -0,0 +1,236 @@
+package macaroon_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+
+	gc "gopkg.in/check.v1"
+
+	"github.com/iron-io/macaroon"
+)
+
+type usageSuite struct{}
+
+var _ = gc.Suite(&usageSuite{})
+
+func (*usageSuite) TestUsesInPrimary(c *gc.C) {
+	rootKey := []byte("secret")
+	m := MustNew(rootKey, "some id", "")
+	err := m.AddFirstPartyCaveat(macaroon.UsesCaveat("reset-1234", 2))
+	c.Assert(err, gc.IsNil)
+
+	store := macaroon.NewMemUsageStore()
+	checker := macaroon.NewChecker()
+	checker.Usage = store
+	for i := 0; i < 2; i++ {
+		_, err := checker.Verify(context.Background(), rootKey, macaroon.Slice{m}, nil)
+		c.Assert(err, gc.IsNil)
+	}
+	c.Assert(store.Uses("reset-1234"), gc.Equals, 2)
+	_, err = checker.Verify(context.Background(), rootKey, macaroon.Slice{m}, nil)
+	c.Assert(err, gc.ErrorMatches, `nonce "reset-1234" has already been used 2 times`)
+	var uerr *macaroon.UsageExhaustedError
+	c.Assert(errors.As(err, &uerr), gc.Equals, true)
+	c.Assert(uerr, gc.DeepEquals, &macaroon.UsageExhaustedError{
+		Nonce:   "reset-1234",
+		MaxUses: 2,
+	})
+	c.Assert(store.Uses("reset-1234"), gc.Equals, 2)
+}
+
+func (*usageSuite) TestUsesInDischarge(c *gc.C) {
+	rootKey := []byte("secret")
+	cav, err := macaroon.NewUsesCaveat(nil, 1)
+	c.Assert(err, gc.IsNil)
+	m := MustNew(rootKey, "some id", "")
+	err = m.AddThirdPartyCaveat([]byte("third party key"), "is-ok", "remote")
+	c.Assert(err, gc.IsNil)
+	dm := MustNew([]byte("third party key"), "is-ok", "remote")
+	err = dm.AddFirstPartyCaveat(cav)
+	c.Assert(err, gc.IsNil)
+	s := macaroon.Slice{m, dm}
+	s.Bind()
+
+	checker := macaroon.NewChecker()
+	checker.Usage = macaroon.NewMemUsageStore()
+	_, err = checker.Verify(context.Background(), rootKey, s, nil)
+	c.Assert(err, gc.IsNil)
+	_, err = checker.Verify(context.Background(), rootKey, s, nil)
+	c.Assert(err, gc.ErrorMatches, `nonce "[0-9a-f]{32}" has already been used`)
+	var uerr *macaroon.UsageExhaustedError
+	c.Assert(errors.As(err, &uerr), gc.Equals, true)
+}
+
+func (*usageSuite) TestUsesConcurrent(c *gc.C) {
+	rootKey := []byte("secret")
+	m := MustNew(rootKey, "some id", "")
+	err := m.AddFirstPartyCaveat(macaroon.UsesCaveat("upload", 5))
+	c.Assert(err, gc.IsNil)
+
+	store := macaroon.NewMemUsageStore()
+	checker := macaroon.NewChecker()
+	checker.Usage = store
+	var (
+		wg sync.WaitGroup
+		mu sync.Mutex
+		ok int
+	)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := checker.Verify(context.Background(), rootKey, macaroon.Slice{m}, nil); err == nil {
+				mu.Lock()
+				ok++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	c.Assert(ok, gc.Equals, 5)
+	c.Assert(store.Uses("upload"), gc.Equals, 5)
+}
+
+func (*usageSuite) TestUsesNotRecordedOnFailure(c *gc.C) {
+	rootKey := []byte("secret")
+	cav := macaroon.UsesCaveat("nonce1", 1)
+	store := macaroon.NewMemUsageStore()
+	checker := macaroon.NewChecker()
+	checker.Usage = store
+
+	// A macaroon forged with the wrong root key
+	// does not use up the nonce.
+	forged := MustNew([]byte("wrong key"), "some id", "")
+	err := forged.AddFirstPartyCaveat(cav)
+	c.Assert(err, gc.IsNil)
+	_, err = checker.Verify(context.Background(), rootKey, macaroon.Slice{forged}, nil)
+	c.Assert(err, gc.ErrorMatches, `signature mismatch after caveat verification`)
+	c.Assert(store.Uses("nonce1"), gc.Equals, 0)
+
+	// Nor does a genuine macaroon that fails a later caveat.
+	m := MustNew(rootKey, "some id", "")
+	err = m.AddFirstPartyCaveat(cav)
+	c.Assert(err, gc.IsNil)
+	expired, err := m.Restrict(macaroon.TimeBeforeCaveat(t0))
+	c.Assert(err, gc.IsNil)
+	_, err = checker.Verify(context.Background(), rootKey, macaroon.Slice{expired}, nil)
+	c.Assert(err, gc.ErrorMatches, `caveat "time-before .*" not satisfied: macaroon has expired`)
+	c.Assert(store.Uses("nonce1"), gc.Equals, 0)
+
+	// Nor does one with a missing discharge.
+	needsDischarge := m.Clone()
+	err = needsDischarge.AddThirdPartyCaveat([]byte("third party key"), "is-ok", "remote")
+	c.Assert(err, gc.IsNil)
+	_, err = checker.Verify(context.Background(), rootKey, macaroon.Slice{needsDischarge}, nil)
+	c.Assert(err, gc.ErrorMatches, `cannot find discharge macaroon for caveat "is-ok"`)
+	c.Assert(store.Uses("nonce1"), gc.Equals, 0)
+
+	// The genuine macaroon can still be used.
+	_, err = checker.Verify(context.Background(), rootKey, macaroon.Slice{m}, nil)
+	c.Assert(err, gc.IsNil)
+	c.Assert(store.Uses("nonce1"), gc.Equals, 1)
+}
+
+func (*usageSuite) TestUsesSameNonceTwice(c *gc.C) {
+	// A nonce that appears in more than one caveat
+	// is used once per verification.
+	rootKey := []byte("secret")
+	m := MustNew(rootKey, "some id", "")
+	err := m.AddFirstPartyCaveat(macaroon.UsesCaveat("nonce", 3))
+	c.Assert(err, gc.IsNil)
+	err = m.AddFirstPartyCaveat(macaroon.UsesCaveat("nonce", 2))
+	c.Assert(err, gc.IsNil)
+	store := macaroon.NewMemUsageStore()
+	checker := macaroon.NewChecker()
+	checker.Usage = store
+	for i := 0; i < 2; i++ {
+		_, err := checker.Verify(context.Background(), rootKey, macaroon.Slice{m}, nil)
+		c.Assert(err, gc.IsNil)
+	}
+	_, err = checker.Verify(context.Background(), rootKey, macaroon.Slice{m}, nil)
+	c.Assert(err, gc.ErrorMatches, `nonce "nonce" has already been used 2 times`)
+	c.Assert(store.Uses("nonce"), gc.Equals, 2)
+}
+
+func (*usageSuite) TestUsesCached(c *gc.C) {
+	// A cached verification result does not allow a
+	// one-time macaroon to be used again.
+	rootKey := []byte("secret")
+	m := MustNew(rootKey, "some id", "")
+	err := m.AddFirstPartyCaveat(macaroon.UsesCaveat("once", 1))
+	c.Assert(err, gc.IsNil)
+	store := macaroon.NewMemUsageStore()
+	checker := macaroon.NewChecker()
+	checker.Usage = store
+	opts := &macaroon.VerifyOptions{
+		Cache: macaroon.NewVerifyCache(10),
+		Dynamic: func(string) bool {
+			return false
+		},
+	}
+	_, err = checker.Verify(context.Background(), rootKey, macaroon.Slice{m}, opts)
+	c.Assert(err, gc.IsNil)
+	c.Assert(opts.Cache.Len(), gc.Equals, 1)
+	for i := 0; i < 2; i++ {
+		_, err = checker.Verify(context.Background(), rootKey, macaroon.Slice{m}, opts)
+		c.Assert(err, gc.ErrorMatches, `nonce "once" has already been used`)
+		var uerr *macaroon.UsageExhaustedError
+		c.Assert(errors.As(err, &uerr), gc.Equals, true)
+	}
+	c.Assert(store.Uses("once"), gc.Equals, 1)
+}
+
+var usesErrorTests = []struct {
+	about     string
+	caveat    string
+	noStore   bool
+	expectErr string
+}{{
+	about:     "no maximum",
+	caveat:    "uses nonce",
+	expectErr: `invalid uses caveat "nonce"`,
+}, {
+	about:     "empty nonce",
+	caveat:    "uses  3",
+	expectErr: `invalid uses caveat " 3"`,
+}, {
+	about:     "bad maximum",
+	caveat:    "uses nonce many",
+	expectErr: `invalid maximum uses "many"`,
+}, {
+	about:     "zero maximum",
+	caveat:    "uses nonce 0",
+	expectErr: `invalid maximum uses "0"`,
+}, {
+	about:     "no store",
+	caveat:    "uses nonce 1",
+	noStore:   true,
+	expectErr: `no usage store`,
+}, {
+	about:     "not checked by Checker.Verify",
+	caveat:    "uses nonce 1",
+	expectErr: `uses caveats can only be checked by Checker.Verify`,
+}}
+
+func (*usageSuite) TestUsesErrors(c *gc.C) {
+	for i, test := range usesErrorTests {
+		c.Logf("test %d: %s", i, test.about)
+		checker := macaroon.NewChecker()
+		if !test.noStore {
+			checker.Usage = macaroon.NewMemUsageStore()
+		}
+		err := checker.CheckFirstPartyCaveat(context.Background(), test.caveat)
+		c.Assert(err, gc.ErrorMatches, `caveat ".*" not satisfied: `+test.expectErr)
+	}
+}
+
+func (*usageSuite) TestNewUsesCaveat(c *gc.C) {
+	cav, err := macaroon.NewUsesCaveat(zeroReader{}, 3)
+	c.Assert(err, gc.IsNil)
+	c.Assert(cav, gc.Equals, "uses "+strings.Repeat("0", 32)+" 3")
+
+	_, err = macaroon.NewUsesCaveat(&macaroon.ErrorReader{}, 3)
+	c.Assert(err, gc.ErrorMatches, `cannot generate random bytes: .*`)
+}
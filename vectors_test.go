@@ -0,0 +1,181 @@
+package macaroon_test
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	gc "gopkg.in/check.v1"
+
+	"github.com/iron-io/macaroon"
+)
+
+type vectorsSuite struct{}
+
+var _ = gc.Suite(&vectorsSuite{})
+
+// vectorMacaroon holds a macaroon from testdata/vectors.json.
+// The vectors are taken from the libmacaroons and pymacaroons
+// documentation and test suites.
+type vectorMacaroon struct {
+	About     string          `json:"about"`
+	RootKey   string          `json:"rootKey"`
+	Id        string          `json:"id"`
+	Location  string          `json:"location"`
+	Caveats   []vectorCaveat  `json:"caveats"`
+	Signature string          `json:"signature"`
+	JSON      json.RawMessage `json:"json"`
+	V1        string          `json:"v1"`
+	V2        string          `json:"v2"`
+
+	// The following fields are only set for discharge macaroons.
+	BoundSignature string `json:"boundSignature"`
+
+	// The following field is only set for primary macaroons.
+	Discharges []vectorMacaroon `json:"discharges"`
+}
+
+// vectorCaveat holds a caveat from testdata/vectors.json.
+// Third party caveats hold the root key shared with the
+// third party; their verification ids are created with an
+// all-zero nonce.
+type vectorCaveat struct {
+	Id       string `json:"cid"`
+	Location string `json:"cl"`
+	RootKey  string `json:"rootKey"`
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(buf []byte) (int, error) {
+	for i := range buf {
+		buf[i] = 0
+	}
+	return len(buf), nil
+}
+
+func (v vectorMacaroon) mint(c *gc.C) *macaroon.Macaroon {
+	m, err := macaroon.New([]byte(v.RootKey), v.Id, v.Location)
+	c.Assert(err, gc.IsNil)
+	for _, cav := range v.Caveats {
+		if cav.RootKey != "" {
+			err = m.AddThirdPartyCaveatWithRand([]byte(cav.RootKey), cav.Id, cav.Location, zeroReader{})
+		} else {
+			err = m.AddFirstPartyCaveat(cav.Id)
+		}
+		c.Assert(err, gc.IsNil)
+	}
+	c.Assert(hex.EncodeToString(m.Signature()), gc.Equals, v.Signature)
+	return m
+}
+
+// conditions returns all the first party caveat conditions
+// in v and its discharges.
+func (v vectorMacaroon) conditions() map[string]bool {
+	conds := make(map[string]bool)
+	for _, cav := range v.Caveats {
+		if cav.RootKey == "" {
+			conds[cav.Id] = true
+		}
+	}
+	for _, d := range v.Discharges {
+		for cond := range d.conditions() {
+			conds[cond] = true
+		}
+	}
+	return conds
+}
+
+// decodeBase64 decodes data in either standard or URL-safe
+// base64 with or without padding, as found in the vectors.
+func decodeBase64(c *gc.C, data string) []byte {
+	data = strings.TrimRight(data, "=")
+	data = strings.NewReplacer("+", "-", "/", "_").Replace(data)
+	b, err := base64.RawURLEncoding.DecodeString(data)
+	c.Assert(err, gc.IsNil)
+	return b
+}
+
+func (*vectorsSuite) TestVectors(c *gc.C) {
+	data, err := ioutil.ReadFile("testdata/vectors.json")
+	c.Assert(err, gc.IsNil)
+	var vectors []vectorMacaroon
+	err = json.Unmarshal(data, &vectors)
+	c.Assert(err, gc.IsNil)
+	for i, v := range vectors {
+		c.Logf("test %d: %s", i, v.About)
+		m := v.mint(c)
+		checkVectorSerialization(c, v, m)
+
+		var discharges []*macaroon.Macaroon
+		for j, dv := range v.Discharges {
+			c.Logf("discharge %d", j)
+			dm := dv.mint(c)
+			checkVectorSerialization(c, dv, dm)
+			dm.Bind(m.Signature())
+			c.Assert(hex.EncodeToString(dm.Signature()), gc.Equals, dv.BoundSignature)
+			discharges = append(discharges, dm)
+		}
+		conds := v.conditions()
+		check := func(cond string) error {
+			if conds[cond] {
+				return nil
+			}
+			return fmt.Errorf("condition %q not met", cond)
+		}
+		err := m.Verify([]byte(v.RootKey), check, discharges)
+		c.Assert(err, gc.IsNil)
+		err = m.Verify([]byte("wrong key"), check, discharges)
+		c.Assert(err, gc.NotNil)
+	}
+}
+
+// checkVectorSerialization checks that m serializes as described by v
+// in all the supported formats, and that it can be deserialized again.
+func checkVectorSerialization(c *gc.C, v vectorMacaroon, m *macaroon.Macaroon) {
+	if v.JSON != nil {
+		data, err := json.Marshal(m)
+		c.Assert(err, gc.IsNil)
+		var got, want interface{}
+		err = json.Unmarshal(data, &got)
+		c.Assert(err, gc.IsNil)
+		err = json.Unmarshal(v.JSON, &want)
+		c.Assert(err, gc.IsNil)
+		c.Assert(got, gc.DeepEquals, want)
+
+		var m1 macaroon.Macaroon
+		err = json.Unmarshal(v.JSON, &m1)
+		c.Assert(err, gc.IsNil)
+		assertEqualMacaroons(c, m, &m1)
+	}
+
+	data, err := m.MarshalV1()
+	c.Assert(err, gc.IsNil)
+	if v.V1 != "" {
+		c.Assert(string(data), gc.Equals, string(decodeBase64(c, v.V1)))
+	}
+	var m1 macaroon.Macaroon
+	err = m1.UnmarshalV1(data)
+	c.Assert(err, gc.IsNil)
+	assertEqualMacaroons(c, m, &m1)
+
+	data, err = m.MarshalV2()
+	c.Assert(err, gc.IsNil)
+	if v.V2 != "" {
+		c.Assert(data, gc.DeepEquals, decodeBase64(c, v.V2))
+	}
+	var m2 macaroon.Macaroon
+	err = m2.UnmarshalV2(data)
+	c.Assert(err, gc.IsNil)
+	assertEqualMacaroons(c, m, &m2)
+
+	data, err = m.MarshalBinary()
+	c.Assert(err, gc.IsNil)
+	var m3 macaroon.Macaroon
+	err = m3.UnmarshalBinary(data)
+	c.Assert(err, gc.IsNil)
+	assertEqualMacaroons(c, m, &m3)
+}
@@ -0,0 +1,146 @@
+package macaroon
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/binary"
+	"sync"
+)
+
+// VerifyCache records successful verifications so that verifying
+// the same macaroons again with the same root key and limits can
+// skip the cryptographic checks. It holds a bounded number of
+// entries, discarding the least recently used ones first. It is
+// safe to use concurrently.
+//
+// See VerifyOptions.Cache.
+type VerifyCache struct {
+	mu      sync.Mutex
+	size    int
+	entries map[verifyCacheKey]*list.Element
+	lru     list.List
+}
+
+// verifyCacheKey holds the SHA-256 digest of a root key, the
+// verification limits and the binary encoding of a macaroon and its
+// discharges. The full digest is used rather than a Fingerprint so
+// that finding a collision is infeasible even for an attacker that
+// controls both macaroons.
+type verifyCacheKey [sha256.Size]byte
+
+// NewVerifyCache returns a cache that holds at most
+// size verification results.
+func NewVerifyCache(size int) *VerifyCache {
+	return &VerifyCache{
+		size:    size,
+		entries: make(map[verifyCacheKey]*list.Element),
+	}
+}
+
+// Len returns the number of entries in the cache.
+func (c *VerifyCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
+
+// contains reports whether the given key is in the cache,
+// marking it as recently used if so.
+func (c *VerifyCache) contains(key verifyCacheKey) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if ok {
+		c.lru.MoveToFront(e)
+	}
+	return ok
+}
+
+// add adds the given key to the cache.
+func (c *VerifyCache) add(key verifyCacheKey) {
+	if c.size <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[key]; ok {
+		c.lru.MoveToFront(e)
+		return
+	}
+	for c.lru.Len() >= c.size {
+		e := c.lru.Back()
+		c.lru.Remove(e)
+		delete(c.entries, e.Value.(verifyCacheKey))
+	}
+	c.entries[key] = c.lru.PushFront(key)
+}
+
+func newVerifyCacheKey(rootKey []byte, limits *Limits, m *Macaroon, discharges []*Macaroon) verifyCacheKey {
+	rootKeyHash := sha256.Sum256(rootKey)
+	h := sha256.New()
+	h.Write(rootKeyHash[:])
+	// A result obtained with lax limits must not be
+	// used for a verification with stricter ones.
+	var limitsBuf [5 * 8]byte
+	for i, n := range [...]int{
+		limits.MaxSize,
+		limits.MaxCaveats,
+		limits.MaxDischarges,
+		limits.MaxIdLen,
+		limits.MaxDepth,
+	} {
+		binary.LittleEndian.PutUint64(limitsBuf[i*8:], uint64(n))
+	}
+	h.Write(limitsBuf[:])
+	m.writeBinary(h)
+	for _, dm := range discharges {
+		dm.writeBinary(h)
+	}
+	var key verifyCacheKey
+	h.Sum(key[:0])
+	return key
+}
+
+// verifyCached performs the checks that are still required when
+// m and its discharges are known to have been verified successfully
+// with the same root key: the revocation checks and the checks of any
+// dynamic first party caveats (see verifier.isDynamic).
+func (m *Macaroon) verifyCached(v *verifier) error {
+	if err := m.recheck(v, RevokedMacaroonId); err != nil {
+		return err
+	}
+	// A successful verification used every
+	// discharge exactly once.
+	for _, dm := range v.discharges {
+		if err := dm.recheck(v, RevokedDischargeId); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *Macaroon) recheck(v *verifier, kind RevocationKind) error {
+	if v.revocation != nil {
+		if err := checkRevoked(v.revocation, kind, m.Id()); err != nil {
+			return err
+		}
+	}
+	for i, cav := range m.caveats {
+		if cav.isThirdParty() {
+			continue
+		}
+		cond := m.dataStr(cav.caveatId)
+		if v.revocation != nil {
+			if err := checkRevoked(v.revocation, RevokedCondition, cond); err != nil {
+				return err
+			}
+		}
+		if !v.isDynamic(m, i, cond) {
+			continue
+		}
+		if err := v.checkCaveat(m, i, cond); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,306 @@
+package macaroon_test
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	gc "gopkg.in/check.v1"
+
+	"github.com/iron-io/macaroon"
+)
+
+type verifyCacheSuite struct{}
+
+var _ = gc.Suite(&verifyCacheSuite{})
+
+// countingCheck returns a check function that allows any
+// condition and records how many times each one was checked.
+func countingCheck() (func(string) error, map[string]int) {
+	checked := make(map[string]int)
+	return func(cond string) error {
+		checked[cond]++
+		return nil
+	}, checked
+}
+
+func (*verifyCacheSuite) TestCacheHit(c *gc.C) {
+	rootKey, primary, discharges := makeMacaroons(recursiveThirdPartyCaveatMacaroons)
+	cache := macaroon.NewVerifyCache(10)
+	opts := &macaroon.VerifyOptions{
+		Cache: cache,
+		Dynamic: func(cond string) bool {
+			return cond == "splendid"
+		},
+	}
+	check, checked := countingCheck()
+	err := primary.VerifyWithOptions(rootKey, check, discharges, opts)
+	c.Assert(err, gc.IsNil)
+	c.Assert(cache.Len(), gc.Equals, 1)
+	c.Assert(checked, gc.DeepEquals, map[string]int{
+		"wonderful":   1,
+		"splendid":    2,
+		"spiffing":    1,
+		"high-fiving": 1,
+	})
+
+	// The second time, only the dynamic conditions are checked.
+	check, checked = countingCheck()
+	err = primary.VerifyWithOptions(rootKey, check, discharges, opts)
+	c.Assert(err, gc.IsNil)
+	c.Assert(checked, gc.DeepEquals, map[string]int{
+		"splendid": 2,
+	})
+
+	// A failing dynamic check still fails verification.
+	err = primary.VerifyWithOptions(rootKey, never, discharges, opts)
+	c.Assert(err, gc.ErrorMatches, `condition is never true`)
+
+	// With no Dynamic function, every condition is checked.
+	check, checked = countingCheck()
+	err = primary.VerifyWithOptions(rootKey, check, discharges, &macaroon.VerifyOptions{
+		Cache: cache,
+	})
+	c.Assert(err, gc.IsNil)
+	c.Assert(checked, gc.HasLen, 4)
+}
+
+func (*verifyCacheSuite) TestCacheMiss(c *gc.C) {
+	rootKey, primary, discharges := makeMacaroons(recursiveThirdPartyCaveatMacaroons)
+	cache := macaroon.NewVerifyCache(10)
+	opts := &macaroon.VerifyOptions{
+		Cache: cache,
+		Dynamic: func(string) bool {
+			return false
+		},
+	}
+	err := primary.VerifyWithOptions(rootKey, never, discharges, opts)
+	c.Assert(err, gc.ErrorMatches, `condition is never true`)
+	c.Assert(cache.Len(), gc.Equals, 0)
+
+	check, _ := countingCheck()
+	err = primary.VerifyWithOptions(rootKey, check, discharges, opts)
+	c.Assert(err, gc.IsNil)
+	c.Assert(cache.Len(), gc.Equals, 1)
+
+	// A different root key does not hit the cache.
+	err = primary.VerifyWithOptions([]byte("wrong key"), check, discharges, opts)
+	c.Assert(err, gc.ErrorMatches, `failed to decrypt caveat 1 signature: decryption failure`)
+
+	// Nor do different discharges.
+	err = primary.VerifyWithOptions(rootKey, check, discharges[1:], opts)
+	c.Assert(err, gc.ErrorMatches, `cannot find discharge macaroon for caveat .*`)
+
+	// Nor does a changed macaroon.
+	primary1, err := primary.Restrict("extra")
+	c.Assert(err, gc.IsNil)
+	err = primary1.VerifyWithOptions(rootKey, check, discharges, opts)
+	c.Assert(err, gc.ErrorMatches, `signature mismatch after caveat verification`)
+	c.Assert(cache.Len(), gc.Equals, 1)
+}
+
+func (*verifyCacheSuite) TestCacheLimits(c *gc.C) {
+	rootKey, primary, discharges := makeMacaroons(recursiveThirdPartyCaveatMacaroons)
+	cache := macaroon.NewVerifyCache(10)
+	check, _ := countingCheck()
+	// The deepest discharge is at depth 3.
+	err := primary.VerifyWithOptions(rootKey, check, discharges, &macaroon.VerifyOptions{
+		Cache:  cache,
+		Limits: &macaroon.Limits{MaxDepth: 3},
+	})
+	c.Assert(err, gc.IsNil)
+	c.Assert(cache.Len(), gc.Equals, 1)
+
+	// A result cached with laxer limits is not used.
+	err = primary.VerifyWithOptions(rootKey, check, discharges, &macaroon.VerifyOptions{
+		Cache:  cache,
+		Limits: &macaroon.Limits{MaxDepth: 2},
+	})
+	c.Assert(err, gc.ErrorMatches, `macaroon limit exceeded: MaxDepth is 2`)
+	c.Assert(cache.Len(), gc.Equals, 1)
+}
+
+func (*verifyCacheSuite) TestCacheRevocation(c *gc.C) {
+	rootKey, primary, discharges := makeMacaroons(recursiveThirdPartyCaveatMacaroons)
+	store := macaroon.NewMemRevocationStore()
+	opts := &macaroon.VerifyOptions{
+		Cache:      macaroon.NewVerifyCache(10),
+		Revocation: store,
+		Dynamic: func(string) bool {
+			return false
+		},
+	}
+	check, _ := countingCheck()
+	err := primary.VerifyWithOptions(rootKey, check, discharges, opts)
+	c.Assert(err, gc.IsNil)
+	for i, test := range revocationTests {
+		if test.expectErr == "" {
+			continue
+		}
+		c.Logf("test %d: %s", i, test.about)
+		store := macaroon.NewMemRevocationStore()
+		err := store.Revoke(test.kind, test.value)
+		c.Assert(err, gc.IsNil)
+		opts.Revocation = store
+		err = primary.VerifyWithOptions(rootKey, check, discharges, opts)
+		c.Assert(err, gc.ErrorMatches, test.expectErr)
+	}
+}
+
+func (*verifyCacheSuite) TestCacheEviction(c *gc.C) {
+	rootKey := []byte("secret")
+	cache := macaroon.NewVerifyCache(2)
+	opts := &macaroon.VerifyOptions{
+		Cache: cache,
+		Dynamic: func(string) bool {
+			return false
+		},
+	}
+	ms := make([]*macaroon.Macaroon, 3)
+	for i := range ms {
+		ms[i] = MustNew(rootKey, fmt.Sprint("id", i), "")
+		err := ms[i].AddFirstPartyCaveat("static")
+		c.Assert(err, gc.IsNil)
+	}
+	verify := func(m *macaroon.Macaroon) int {
+		check, checked := countingCheck()
+		err := m.VerifyWithOptions(rootKey, check, nil, opts)
+		c.Assert(err, gc.IsNil)
+		return checked["static"]
+	}
+	c.Assert(verify(ms[0]), gc.Equals, 1)
+	c.Assert(verify(ms[1]), gc.Equals, 1)
+	c.Assert(verify(ms[0]), gc.Equals, 0)
+	// ms[1] is now the least recently used,
+	// so it is evicted.
+	c.Assert(verify(ms[2]), gc.Equals, 1)
+	c.Assert(cache.Len(), gc.Equals, 2)
+	c.Assert(verify(ms[0]), gc.Equals, 0)
+	c.Assert(verify(ms[1]), gc.Equals, 1)
+}
+
+func (*verifyCacheSuite) TestCheckerCache(c *gc.C) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	checker := macaroon.NewChecker()
+	checker.Clock = func() time.Time {
+		return now
+	}
+	checked := make(map[string]int)
+	checker.Register("static", func(ctx context.Context, name, arg string) error {
+		checked[name+" "+arg]++
+		return nil
+	})
+	checker.RegisterNSDynamic("https://example.com/ns", "p", "dynamic", func(ctx context.Context, name, arg string) error {
+		checked[name+" "+arg]++
+		return nil
+	})
+	expiry := macaroon.TimeBeforeCaveat(now.Add(time.Minute))
+	exprCav, err := macaroon.ExprCaveat(macaroon.Or(
+		macaroon.Leaf(expiry),
+		macaroon.Leaf("static 2"),
+	))
+	c.Assert(err, gc.IsNil)
+
+	rootKey := []byte("secret")
+	m := MustNew(rootKey, "some id", "")
+	for _, cav := range []string{
+		"static 1",
+		"p:dynamic 1",
+		exprCav,
+	} {
+		err := m.AddFirstPartyCaveat(cav)
+		c.Assert(err, gc.IsNil)
+	}
+	m1, err := m.Restrict(expiry)
+	c.Assert(err, gc.IsNil)
+
+	// The caller's Dynamic function is extended with the
+	// conditions that the checker reports as dynamic.
+	opts := &macaroon.VerifyOptions{
+		Cache: macaroon.NewVerifyCache(10),
+		Dynamic: func(cav string) bool {
+			return cav == "static 1"
+		},
+	}
+	_, err = checker.Verify(context.Background(), rootKey, macaroon.Slice{m1}, opts)
+	c.Assert(err, gc.IsNil)
+	c.Assert(checked, gc.DeepEquals, map[string]int{
+		"static 1":    1,
+		"p:dynamic 1": 1,
+	})
+
+	checked = make(map[string]int)
+	_, err = checker.Verify(context.Background(), rootKey, macaroon.Slice{m1}, opts)
+	c.Assert(err, gc.IsNil)
+	c.Assert(checked, gc.DeepEquals, map[string]int{
+		"static 1":    1,
+		"p:dynamic 1": 1,
+	})
+
+	// Once the macaroon has expired, the cached
+	// result does not allow it to be used.
+	now = now.Add(2 * time.Minute)
+	_, err = checker.Verify(context.Background(), rootKey, macaroon.Slice{m1}, opts)
+	c.Assert(err, gc.ErrorMatches, `caveat "time-before .*" not satisfied: macaroon has expired`)
+
+	// A condition in an expr caveat is checked again
+	// if it is dynamic, even when the caller's Dynamic
+	// function reports nothing as dynamic.
+	opts.Dynamic = func(string) bool {
+		return false
+	}
+	checked = make(map[string]int)
+	_, err = checker.Verify(context.Background(), rootKey, macaroon.Slice{m}, opts)
+	c.Assert(err, gc.IsNil)
+	c.Assert(checked, gc.DeepEquals, map[string]int{
+		"static 1":    1,
+		"p:dynamic 1": 1,
+		"static 2":    1,
+	})
+	checked = make(map[string]int)
+	_, err = checker.Verify(context.Background(), rootKey, macaroon.Slice{m}, opts)
+	c.Assert(err, gc.IsNil)
+	c.Assert(checked, gc.DeepEquals, map[string]int{
+		"p:dynamic 1": 1,
+		"static 2":    1,
+	})
+}
+
+var isDynamicTests = []struct {
+	caveat string
+	expect bool
+}{
+	{"declared a b", false},
+	{"ns p:https://example.com/ns", false},
+	{"static", false},
+	{"time-before 2020-01-01T00:00:00Z", true},
+	{"http-method GET", true},
+	{"http-path /foo", true},
+	{"http-host example.com", true},
+	{"client-ip 127.0.0.1/32", true},
+	{"holder-key xxx", true},
+	{"uses nonce 1", true},
+	{"p:dynamic", true},
+	{"p:static", false},
+	{"q:static", true},
+	{"unknown", true},
+	{" bad", true},
+	{`expr and("static","declared a b")`, false},
+	{`expr and("static",or("declared a b","p:dynamic"))`, true},
+	{`expr not("http-method GET")`, true},
+	{`expr bad`, true},
+}
+
+func (*verifyCacheSuite) TestIsDynamic(c *gc.C) {
+	checker := macaroon.NewChecker()
+	ok := func(context.Context, string, string) error {
+		return nil
+	}
+	checker.Register("static", ok)
+	checker.RegisterNS("https://example.com/ns", "p", "static", ok)
+	checker.RegisterNSDynamic("https://example.com/ns", "p", "dynamic", ok)
+	for i, test := range isDynamicTests {
+		c.Logf("test %d: %q", i, test.caveat)
+		c.Assert(checker.IsDynamic(context.Background(), test.caveat), gc.Equals, test.expect)
+	}
+}